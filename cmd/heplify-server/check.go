@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/rotator"
+	input "github.com/sipcapture/heplify-server/server"
+)
+
+// checkConfig validates the configuration file without starting the
+// server. It returns every problem found.
+func checkConfig(path string) config.Errors {
+	if _, err := os.Stat(path); err != nil {
+		return config.Errors{err}
+	}
+	cfg, errs := config.Check(path)
+	if cfg == nil {
+		return errs
+	}
+
+	if err, ok := input.CheckOutputPolicies(cfg).(config.Errors); ok {
+		errs = append(errs, err...)
+	}
+
+	for _, part := range []struct{ name, value string }{
+		{"DBPartLog", cfg.DBPartLog},
+		{"DBPartIsup", cfg.DBPartIsup},
+		{"DBPartSip", cfg.DBPartSip},
+		{"DBPartQos", cfg.DBPartQos},
+	} {
+		if _, err := rotator.Step(part.value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %v", part.name, err))
+		}
+	}
+
+	if cfg.ScriptEnable {
+		config.Setting = *cfg
+		if cfg.ScriptFolder == "" {
+			errs = append(errs, fmt.Errorf("ScriptEnable is set without a ScriptFolder"))
+		} else if s, err := decoder.NewScriptEngine(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid scripts in %s: %v", cfg.ScriptFolder, err))
+		} else {
+			s.Close()
+		}
+	}
+	return errs
+}
+
+// runCheck prints the result of checkConfig and exits non-zero on errors.
+func runCheck(path string) {
+	errs := checkConfig(path)
+	if len(errs) == 0 {
+		fmt.Printf("%s: OK\n", path)
+		os.Exit(0)
+	}
+	printErrors(path, errs)
+	os.Exit(1)
+}
+
+// printErrors prints every problem of err prefixed with the file name.
+func printErrors(path string, err error) {
+	errs, ok := err.(config.Errors)
+	if !ok {
+		errs = config.Errors{err}
+	}
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+	}
+}
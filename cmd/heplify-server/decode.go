@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/database"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/pcap"
+)
+
+// runDecode prints how heplify-server decodes the packets of a hex dump,
+// a raw binary file or a pcap file.
+func runDecode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	hexArg := fs.String("hex", "", "decode the packet given as hex string")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s decode [-hex 48455033...] [file...]\n"+
+			"Files can be pcap, pcapng, hex dumps or raw binary packets, - reads stdin.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// show every packet instead of dropping repeated ones
+	config.Setting.Dedup = false
+
+	if *hexArg != "" {
+		b, ok := parseHexDump([]byte(*hexArg))
+		if !ok {
+			return fmt.Errorf("invalid hex string")
+		}
+		return decodePackets("hex", b)
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var failed bool
+	for _, name := range files {
+		var data []byte
+		var err error
+		if name == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(name)
+		}
+		if err != nil {
+			return err
+		}
+		if err = decodePackets(name, data); err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("not all packets could be decoded")
+	}
+	return nil
+}
+
+// decodePackets prints all packets of data and returns the last decoding error.
+func decodePackets(name string, data []byte) error {
+	var lastErr error
+	if r, err := pcap.NewReader(bytes.NewReader(data)); err == nil {
+		for n := 1; ; n++ {
+			p, err := r.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			pkts, err := pcap.HEP(p, pcap.Options{})
+			if err == pcap.ErrSkip {
+				continue
+			} else if err != nil {
+				fmt.Printf("%s packet %d: %v\n\n", name, n, err)
+				lastErr = err
+				continue
+			}
+			for _, pkt := range pkts {
+				if err = printPacket(fmt.Sprintf("%s packet %d", name, n), pkt); err != nil {
+					lastErr = err
+				}
+			}
+		}
+		return lastErr
+	}
+
+	if b, ok := parseHexDump(data); ok {
+		data = b
+	}
+	for i, pkt := range splitPackets(data) {
+		if err := printPacket(fmt.Sprintf("%s packet %d", name, i+1), pkt); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// parseHexDump accepts plain hex, with optional \x or colon separators,
+// and Go or C style byte lists.
+func parseHexDump(data []byte) ([]byte, bool) {
+	s := strings.TrimSpace(string(data))
+	if strings.Contains(s, "0x") || strings.Contains(s, ",") {
+		return parseByteList(s)
+	}
+	s = strings.NewReplacer("\\x", "", ":", "").Replace(s)
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	return b, err == nil && len(b) > 0
+}
+
+func parseByteList(s string) ([]byte, bool) {
+	s = strings.NewReplacer("[]byte", "", "{", "", "}", "", ",", " ").Replace(s)
+	var b []byte
+	for _, f := range strings.Fields(s) {
+		f = strings.TrimPrefix(f, "0x")
+		if len(f) == 1 {
+			f = "0" + f
+		}
+		v, err := hex.DecodeString(f)
+		if err != nil || len(v) != 1 {
+			return nil, false
+		}
+		b = append(b, v[0])
+	}
+	return b, len(b) > 0
+}
+
+// splitPackets splits concatenated HEP3 packets. Everything else is one packet.
+func splitPackets(data []byte) [][]byte {
+	var pkts [][]byte
+	for len(data) >= 6 && decoder.IsHEP3(data) {
+		l := int(binary.BigEndian.Uint16(data[4:6]))
+		if l < 6 || l >= len(data) {
+			break
+		}
+		pkts = append(pkts, data[:l])
+		data = data[l:]
+	}
+	if len(data) > 0 {
+		pkts = append(pkts, data)
+	}
+	return pkts
+}
+
+func printPacket(title string, pkt []byte) error {
+	fmt.Printf("=== %s, %d byte\n", title, len(pkt))
+	h, err := decoder.DecodeHEP(pkt)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		if cerr, ok := err.(*decoder.ChunkError); ok {
+			end := cerr.Offset + int(cerr.Length)
+			if end > len(pkt) || end <= cerr.Offset {
+				end = len(pkt)
+			}
+			fmt.Printf("failed chunk: vendor %d, type %d, length %d, offset %d\n%s",
+				cerr.VendorID, cerr.Type, cerr.Length, cerr.Offset, hex.Dump(pkt[cerr.Offset:end]))
+		}
+		fmt.Printf("packet:\n%s\n", hex.Dump(pkt))
+		return err
+	}
+
+	c := *h
+	c.SIP, c.Payload = nil, ""
+	b, _ := json.MarshalIndent(&c, "", "  ")
+	fmt.Printf("HEP:\n%s\n", b)
+	fmt.Printf("Payload:\n%s\n", h.Payload)
+
+	if h.SIP != nil {
+		fmt.Println("SIP:")
+		printFields(reflect.ValueOf(h.SIP).Elem())
+	}
+	pHeader, dHeader := database.Headers(h)
+	fmt.Printf("protocol_header: %s\n", pHeader)
+	if dHeader != "" {
+		fmt.Printf("data_header: %s\n", dHeader)
+	}
+	fmt.Println()
+	return nil
+}
+
+// printFields prints the non empty exported fields of a struct which aren't structs themselves.
+func printFields(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		name := t.Field(i).Name
+		if t.Field(i).PkgPath != "" || name == "Msg" || name == "Body" || f.IsZero() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.String, reflect.Int, reflect.Slice, reflect.Map:
+			fmt.Printf("  %s: %v\n", name, f.Interface())
+		case reflect.Interface:
+			if err, ok := f.Interface().(error); ok {
+				fmt.Printf("  %s: %v\n", name, err)
+			}
+		}
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"html/template"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,7 +23,17 @@ type server interface {
 	End()
 }
 
-func init() {
+// commands are run instead of the server when named as first argument.
+var commands = map[string]func(args []string) error{
+	"decode": runDecode,
+	"import": runImport,
+	"send":   runSend,
+}
+
+// loadConfig loads the flags, the environment and the config file of the
+// server and initializes the logging. An invalid config file stops
+// heplify-server with status 1.
+func loadConfig() {
 	var err error
 	var logging logp.Logging
 
@@ -32,17 +41,33 @@ func init() {
 	cfg := new(config.HeplifyServer)
 	c.MustLoad(cfg)
 	config.Setting = *cfg
+	if config.Setting.Version {
+		fmt.Printf("VERSION: %s\r\n", config.Version)
+		os.Exit(0)
+	}
+	if config.Setting.Check {
+		// check the file strictly instead of falling back to defaults
+		runCheck(config.Setting.Config)
+	}
 
 	if tomlExists(config.Setting.Config) {
-		cf := multiconfig.NewWithPath(config.Setting.Config)
-		err := cf.Load(cfg)
-		if err == nil {
-			config.Setting = *cfg
-		} else {
-			fmt.Println("Syntax error in toml config file, use flag defaults.", err)
+		if cfg, err = config.Load(config.Setting.Config); err == nil {
+			err = input.CheckOutputPolicies(cfg)
+		}
+		if err != nil {
+			printErrors(config.Setting.Config, err)
+			os.Exit(1)
 		}
+		config.Setting = *cfg
 	} else {
 		fmt.Println("Could not find toml config file, use flag defaults.", err)
+		if err = config.Setting.Validate(); err == nil {
+			err = input.CheckOutputPolicies(&config.Setting)
+		}
+		if err != nil {
+			printErrors("flags", err)
+			os.Exit(1)
+		}
 	}
 
 	config.Setting.AlegIDs = config.GenerateRegexMap(config.Setting.AlegIDs)
@@ -80,12 +105,20 @@ func main() {
 	var servers []server
 	var wg sync.WaitGroup
 	var sigCh = make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	if config.Setting.Version {
-		fmt.Printf("VERSION: %s\r\n", config.Version)
-		os.Exit(0)
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			// commands have their own flags and log warnings to stderr
+			logp.LogInit(logp.LOG_WARNING, "", false, true, nil)
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
 	}
+	loadConfig()
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	startServer := func() {
 		hep := input.NewHEPInput()
@@ -111,34 +144,51 @@ func main() {
 		logp.Info("heplify-server has been stopped")
 	}
 
-	if len(config.Setting.ConfigHTTPAddr) > 2 {
-		tmpl := template.Must(template.New("main").Parse(config.WebForm))
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodPost {
-				tmpl.Execute(w, config.Setting)
-				return
+	// dead letters hold raw packets, so they are only listed next to the
+	// admin API or the health checks and never without a token
+	hasToken := len(config.Setting.ConfigHTTPToken) > 0 || config.Setting.ConfigHTTPPW != ""
+	if config.Setting.DeadLetterDir != "" && !hasToken {
+		logp.Warn("DeadLetterDir is set without ConfigHTTPToken, /deadletter is not served")
+	}
+
+	if adminAddr := config.Setting.ConfigHTTPAddr; len(adminAddr) > 2 {
+		if !hasToken {
+			logp.Warn("ConfigHTTPAddr is set without ConfigHTTPToken, all admin requests will be rejected")
+		}
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/", input.AdminHandler())
+			if hasToken {
+				mux.Handle("/deadletter", input.DeadLetterHandler())
+			}
+			err := http.ListenAndServe(adminAddr, mux)
+			if err != nil {
+				logp.Err("%v", err)
 			}
+		}()
+	}
 
-			cfg, err := config.WebConfig(r)
+	if healthAddr := config.Setting.HealthHTTPAddr; len(healthAddr) > 2 {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/", input.HealthHandler())
+			if hasToken {
+				mux.Handle("/deadletter", input.DeadLetterHandler())
+			}
+			err := http.ListenAndServe(healthAddr, mux)
 			if err != nil {
-				logp.Warn("Failed config reload from %v. %v", r.RemoteAddr, err)
-				tmpl.Execute(w, config.Setting)
-				return
+				logp.Err("%v", err)
 			}
-			logp.Info("Successfull config reloaded from %v", r.RemoteAddr)
-			endServer()
-			config.Setting = *cfg
-			logp.SetToSyslog(config.Setting.LogSys, "")
-			tmpl.Execute(w, config.Setting)
-			startServer()
-		})
-
-		go http.ListenAndServe(config.Setting.ConfigHTTPAddr, nil)
+		}()
 	}
 
 	if promAddr := config.Setting.PromAddr; len(promAddr) > 2 {
 		go func() {
 			http.Handle("/metrics", promhttp.Handler())
+			if len(config.Setting.HealthHTTPAddr) <= 2 {
+				http.Handle("/healthz", input.HealthHandler())
+				http.Handle("/readyz", input.HealthHandler())
+			}
 			err := http.ListenAndServe(promAddr, nil)
 			if err != nil {
 				logp.Err("%v", err)
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/pcap"
+	input "github.com/sipcapture/heplify-server/server"
+)
+
+type fileList []string
+
+func (f *fileList) String() string     { return strings.Join(*f, ",") }
+func (f *fileList) Set(v string) error { *f = append(*f, v); return nil }
+
+// importBlockTimeout is how long an import waits for a full output.
+const importBlockTimeout = 10 * time.Minute
+
+type importStats struct {
+	packets, hep, skipped, errors uint64
+}
+
+// runImport pushes the HEP packets and SIP messages of pcap and pcapng files
+// through the decoder, scripts and outputs without opening any listener.
+func runImport(args []string) error {
+	var files fileList
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Var(&files, "pcap", "pcap or pcapng file, can be repeated")
+	cfgFile := fs.String("config", "", "toml config file")
+	nodeID := fs.Uint("nodeid", 0, "HEP node id of SIP messages without HEP encapsulation")
+	nodeName := fs.String("nodename", "", "HEP node name of SIP messages without HEP encapsulation")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import [options] --pcap file.pcap [file.pcapng...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	files = append(files, fs.Args()...)
+	if len(files) == 0 {
+		fs.Usage()
+		return fmt.Errorf("no pcap file given")
+	}
+
+	cfg, err := config.LoadFile(*cfgFile)
+	if err != nil {
+		return fmt.Errorf("can't load %s: %v", *cfgFile, err)
+	}
+	cfg.AlegIDs = config.GenerateRegexMap(cfg.AlegIDs)
+	config.Setting = *cfg
+
+	// only the outputs are used and they have to wait instead of dropping
+	// packets, unless a policy has been configured. The timeout only ends
+	// the wait for an output which doesn't read anymore.
+	config.Setting.HEPAddr, config.Setting.HEPTCPAddr, config.Setting.HEPTLSAddr = "", "", ""
+	config.Setting.HEPWSAddr, config.Setting.HEPUnixAddr, config.Setting.HEPUnixgramAddr = "", "", ""
+	config.Setting.HEPGRPCAddr, config.Setting.HEPHTTPAddr = "", ""
+	policies := []string{}
+	for _, name := range input.Outputs() {
+		policies = append(policies, name+"=block:"+importBlockTimeout.String())
+	}
+	config.Setting.OutputPolicy = append(policies, config.Setting.OutputPolicy...)
+
+	hep := input.NewHEPInput()
+	done := make(chan struct{})
+	go func() {
+		hep.Run()
+		close(done)
+	}()
+	<-hep.Ready()
+
+	opts := pcap.Options{NodeID: uint32(*nodeID), NodeName: *nodeName}
+	start := time.Now()
+	var st importStats
+	for _, f := range files {
+		if err = importFile(hep, f, opts, &st); err != nil {
+			break
+		}
+	}
+
+	hep.End()
+	<-done
+	msg := fmt.Sprintf("imported %d HEP packets from %d captured packets in %v, skipped %d packets, %d errors",
+		st.hep, st.packets, time.Since(start).Round(time.Millisecond), st.skipped, st.errors)
+	logp.Info("%s", msg)
+	fmt.Println(msg)
+	return err
+}
+
+func importFile(hep *input.HEPInput, name string, opts pcap.Options, st *importStats) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		st.packets++
+
+		pkts, err := pcap.HEP(p, opts)
+		if err == pcap.ErrSkip {
+			st.skipped++
+			continue
+		} else if err != nil {
+			logp.Warn("%s: packet %d: %v", name, st.packets, err)
+			st.errors++
+			continue
+		}
+		for _, pkt := range pkts {
+			if err = hep.Inject(pkt); err == input.ErrStopping {
+				return err
+			} else if err != nil {
+				logp.Warn("%s: packet %d: %v", name, st.packets, err)
+				st.errors++
+				continue
+			}
+			st.hep++
+		}
+	}
+}
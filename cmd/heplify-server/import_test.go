@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	input "github.com/sipcapture/heplify-server/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowOutput reads its channel slower than the import writes into it.
+type slowOutput struct {
+	ch    chan *decoder.HEP
+	done  chan struct{}
+	count *uint64
+}
+
+func (o *slowOutput) Run(ch chan *decoder.HEP) error {
+	o.ch, o.done = ch, make(chan struct{})
+	go func() {
+		defer close(o.done)
+		for range ch {
+			time.Sleep(100 * time.Microsecond)
+			atomic.AddUint64(o.count, 1)
+		}
+	}()
+	return nil
+}
+
+func (o *slowOutput) End() {
+	close(o.ch)
+	<-o.done
+}
+
+// udpPcap writes a pcap file with one UDP packet per payload.
+func udpPcap(t *testing.T, path string, payloads [][]byte) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, []uint32{0xa1b2c3d4, 0x00040002, 0, 0, 65535, 1})
+	for _, p := range payloads {
+		frame := []byte{
+			0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 0x08, 0x00,
+			0x45, 0, 0, 0, 0, 1, 0x40, 0, 64, 17, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2,
+			0x23, 0x5c, 0x23, 0x5c, 0, 0, 0, 0,
+		}
+		binary.BigEndian.PutUint16(frame[16:18], uint16(28+len(p)))
+		binary.BigEndian.PutUint16(frame[38:40], uint16(8+len(p)))
+		frame = append(frame, p...)
+		binary.Write(&b, binary.LittleEndian, []uint32{1546300800, 0, uint32(len(frame)), uint32(len(frame))})
+		b.Write(frame)
+	}
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportLosesNothing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config.Setting
+	defer func() { config.Setting = saved }()
+
+	var count uint64
+	input.RegisterOutput("slow", func() (input.Output, input.OutputConfig) {
+		return &slowOutput{count: &count}, input.OutputConfig{Buffer: 1}
+	})
+
+	const n = 500
+	payloads := make([][]byte, n)
+	for i := range payloads {
+		pkt := &decoder.HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2",
+			SrcPort: 9060, DstPort: 9060, Tsec: 1546300800, ProtoType: 100, NodeID: 2001,
+			Payload: "log line " + strconv.Itoa(i)}
+		if payloads[i], err = decoder.EncodeHEP(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "import.pcap")
+	udpPcap(t, file, payloads)
+	cfg := filepath.Join(dir, "heplify-server.toml")
+	if err := ioutil.WriteFile(cfg, []byte("DBAddr = \"\"\nPromAddr = \"\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, runImport([]string{"-config", cfg, "--pcap", file}))
+	assert.Equal(t, uint64(n), atomic.LoadUint64(&count))
+}
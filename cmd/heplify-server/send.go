@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sipcapture/heplify-server/hepgen"
+	"github.com/sipcapture/heplify-server/pcap"
+)
+
+// runSend replays a pcap or JSON file or generates SIP calls and sends
+// them as HEP to a heplify-server listener.
+func runSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:9060", "HEP listener address")
+	network := fs.String("transport", "udp", "udp or tcp")
+	pcapFile := fs.String("pcap", "", "replay the HEP packets and SIP messages of a pcap or pcapng file")
+	jsonFile := fs.String("json", "", "replay a JSON array or newline delimited JSON file with HEP objects")
+	speed := fs.Float64("speed", 1, "replay speed factor, 0 sends as fast as possible")
+	now := fs.Bool("now", false, "overwrite the timestamps with the send time")
+	duration := fs.Duration("duration", 0, "stop after this time, 0 runs until the end or Ctrl-C")
+	cps := fs.Float64("cps", 0, "generate this many calls per second")
+	rps := fs.Float64("rps", 0, "generate this many REGISTER transactions per second")
+	limit := fs.Int("limit", 0, "stop after this many generated calls and registrations")
+	hold := fs.Duration("hold", 30*time.Second, "duration of the generated calls")
+	rtcp := fs.Duration("rtcp", 0, "interval of RTCP reports during generated calls, 0 disables them")
+	nodeID := fs.Uint("nodeid", 2001, "HEP node id of generated packets")
+	nodePW := fs.String("nodepw", "", "HEP node password of generated packets")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s send [options] (-pcap file | -json file | -cps n | -rps n)\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var src hepgen.Source
+	switch {
+	case *pcapFile != "" || *jsonFile != "":
+		name := *pcapFile
+		if name == "" {
+			name = *jsonFile
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if *pcapFile != "" {
+			src, err = hepgen.NewPcapSource(f, pcap.Options{NodeID: uint32(*nodeID)})
+		} else {
+			src, err = hepgen.NewJSONSource(f)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	case *cps > 0 || *rps > 0:
+		src = hepgen.NewGenerator(hepgen.GenConfig{
+			CPS:    *cps,
+			RPS:    *rps,
+			Limit:  *limit,
+			Hold:   *hold,
+			RTCP:   *rtcp,
+			NodeID: uint32(*nodeID),
+			NodePW: *nodePW,
+		})
+	default:
+		fs.Usage()
+		return fmt.Errorf("nothing to send")
+	}
+
+	conn, err := net.Dial(*network, *addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		var timeout <-chan time.Time
+		if *duration > 0 {
+			timeout = time.After(*duration)
+		}
+		select {
+		case <-sigCh:
+		case <-timeout:
+		}
+		close(stop)
+	}()
+
+	s := &hepgen.Sender{Conn: conn, Speed: *speed, Now: *now}
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- s.Run(src, stop) }()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var last uint64
+	for {
+		select {
+		case err = <-done:
+			packets, bytes := s.Stats()
+			elapsed := time.Since(start)
+			fmt.Printf("sent %d packets with %d bytes to %s in %v (%.0f pps)\n",
+				packets, bytes, *addr, elapsed.Round(time.Millisecond), float64(packets)/elapsed.Seconds())
+			return err
+		case <-ticker.C:
+			packets, _ := s.Stats()
+			fmt.Printf("sent %d packets, %.0f pps\n", packets, float64(packets-last)/5)
+			last = packets
+		}
+	}
+}
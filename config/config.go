@@ -1,18 +1,53 @@
 package config
 
+import "sync/atomic"
+
 const Version = "heplify-server 1.53"
 
+// Setting is the configuration of the startup. It must not be changed
+// once the server runs, a reload publishes the new configuration with Set.
 var Setting HeplifyServer
 
+var current atomic.Value
+
+// Get returns the current configuration, Setting until Set was called.
+// The returned configuration must not be changed.
+func Get() *HeplifyServer {
+	if cfg, _ := current.Load().(*HeplifyServer); cfg != nil {
+		return cfg
+	}
+	return &Setting
+}
+
+// Set publishes cfg as the current configuration. Nil returns to Setting.
+func Set(cfg *HeplifyServer) {
+	current.Store(cfg)
+}
+
 type HeplifyServer struct {
 	HEPAddr            string   `default:"0.0.0.0:9060"`
 	HEPTCPAddr         string   `default:""`
 	HEPTLSAddr         string   `default:""`
 	HEPWSAddr          string   `default:""`
+	HEPUnixAddr        string   `default:""`
+	HEPUnixgramAddr    string   `default:""`
+	HEPGRPCAddr        string   `default:""`
+	HEPGRPCTLS         bool     `default:"false"`
+	HEPGRPCToken       string   `default:""`
+	HEPHTTPAddr        string   `default:""`
+	HEPHTTPTLS         bool     `default:"false"`
+	HEPHTTPToken       string   `default:""`
+	HEPHTTPMaxBody     int      `default:"10485760"`
+	HEPUDPSockets      int      `default:"1"`
+	HEPUDPReadBuffer   int      `default:"0"`
+	WorkerShards       int      `default:"0"`
+	WorkerBuffer       int      `default:"1000"`
 	ESAddr             string   `default:""`
 	ESDiscovery        bool     `default:"true"`
 	ESUser             string   `default:""`
 	ESPass             string   `default:""`
+	ESBuffer           int      `default:"40000"`
+	ESHEPFilter        []int    `default:""`
 	LokiURL            string   `default:""`
 	LokiBulk           int      `default:"400"`
 	LokiTimer          int      `default:"4"`
@@ -22,6 +57,12 @@ type HeplifyServer struct {
 	PromAddr           string   `default:":9096"`
 	PromTargetIP       string   `default:""`
 	PromTargetName     string   `default:""`
+	PromBuffer         int      `default:"40000"`
+	PromHEPFilter      []int    `default:""`
+	ForwardAddr        []string `default:""`
+	ForwardBuffer      int      `default:"40000"`
+	ForwardEncode      bool     `default:"false"`
+	OutputPolicy       []string `default:""`
 	DBShema            string   `default:"homer5"`
 	DBDriver           string   `default:"mysql"`
 	DBAddr             string   `default:"localhost:3306"`
@@ -33,6 +74,9 @@ type HeplifyServer struct {
 	DBTimer            int      `default:"4"`
 	DBBuffer           int      `default:"400000"`
 	DBWorker           int      `default:"8"`
+	DBHEPFilter        []int    `default:""`
+	DBSpoolDir         string   `default:""`
+	DBSpoolMaxSize     int      `default:"1024"`
 	DBRotate           bool     `default:"true"`
 	DBPartLog          string   `default:"2h"`
 	DBPartIsup         string   `default:"6h"`
@@ -44,6 +88,8 @@ type HeplifyServer struct {
 	DBDropDaysDefault  int      `default:"0"`
 	DBDropOnStart      bool     `default:"false"`
 	Dedup              bool     `default:"false"`
+	PayloadCompression string   `default:""`
+	PayloadMaxInflate  int      `default:"1048576"`
 	DiscardMethod      []string `default:""`
 	CensorMethod       []string `default:""`
 	AlegIDs            []string `default:""`
@@ -57,10 +103,22 @@ type HeplifyServer struct {
 	Config             string   `default:"./heplify-server.toml"`
 	ConfigHTTPAddr     string   `default:""`
 	ConfigHTTPPW       string   `default:""`
+	ConfigHTTPToken    []string `default:""`
+	ConfigHTTPAudit    string   `default:""`
+	HealthHTTPAddr     string   `default:""`
+	DeadLetterDir      string   `default:""`
+	DeadLetterMaxSize  int      `default:"100"`
 	Version            bool     `default:"false"`
+	Check              bool     `default:"false"`
 	ScriptEnable       bool     `default:"false"`
 	ScriptEngine       string   `default:"lua"`
 	ScriptFolder       string   `default:""`
 	ScriptHEPFilter    []int    `default:"1,5,100"`
 	TLSCertFolder      string   `default:"."`
+	TLSCertFile        string   `default:""`
+	TLSKeyFile         string   `default:""`
+	TLSClientCA        string   `default:""`
+	TLSCertNodeName    bool     `default:"false"`
+	NodeAuth           []string `default:""`
+	NodeAuthFile       string   `default:""`
 }
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/negbie/multiconfig"
+)
+
+// Errors are all problems found in a configuration.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Load reads the TOML file at path on top of the defaults, the environment
+// and the command-line flags and validates the result.
+func Load(path string) (*HeplifyServer, error) {
+	cfg := new(HeplifyServer)
+	if err := multiconfig.NewWithPath(path).Load(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile reads the TOML file at path on top of the defaults like Load,
+// but without the environment and the command-line flags of the server.
+// An empty path returns the defaults.
+func LoadFile(path string) (*HeplifyServer, error) {
+	loaders := []multiconfig.Loader{&multiconfig.TagLoader{}}
+	if path != "" {
+		loaders = append(loaders, &multiconfig.TOMLLoader{Path: path})
+	}
+	cfg := new(HeplifyServer)
+	if err := multiconfig.MultiLoader(loaders...).Load(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Check loads the TOML file at path like Load, but also rejects unknown
+// keys and returns all problems at once.
+func Check(path string) (*HeplifyServer, Errors) {
+	md, err := toml.DecodeFile(path, new(HeplifyServer))
+	if err != nil {
+		return nil, Errors{err}
+	}
+	var errs Errors
+	for _, key := range md.Undecoded() {
+		errs = append(errs, unknownKey(key.String()))
+	}
+
+	cfg := new(HeplifyServer)
+	if err := multiconfig.NewWithPath(path).Load(cfg); err != nil {
+		return nil, append(errs, err)
+	}
+	if err, ok := cfg.Validate().(Errors); ok {
+		errs = append(errs, err...)
+	}
+	return cfg, errs
+}
+
+// unknownKey suggests the setting with the smallest edit distance.
+func unknownKey(key string) error {
+	best, dist := "", 3
+	t := reflect.TypeOf(HeplifyServer{})
+	for i := 0; i < t.NumField(); i++ {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(t.Field(i).Name)); d < dist {
+			best, dist = t.Field(i).Name, d
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("unknown setting %s, did you mean %s?", key, best)
+	}
+	return fmt.Errorf("unknown setting %s", key)
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cur[j] = prev[j-1]
+			if a[i-1] != b[j-1] {
+				cur[j]++
+			}
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+			if d := cur[j-1] + 1; d < cur[j] {
+				cur[j] = d
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// Validate checks the settings which would otherwise only fail
+// when an output or the SIP parser starts to use them.
+func (s *HeplifyServer) Validate() error {
+	var errs Errors
+	if len(s.DBAddr) > 2 && s.DBDriver != "mock" {
+		switch {
+		case s.DBDriver != "mysql" && s.DBDriver != "postgres":
+			errs = append(errs, fmt.Errorf("invalid DBDriver: %s, please use mysql or postgres", s.DBDriver))
+		case s.DBShema != "homer5" && s.DBShema != "homer7":
+			errs = append(errs, fmt.Errorf("invalid DBShema: %s, please use homer5 or homer7", s.DBShema))
+		case s.DBShema == "homer5" && s.DBDriver != "mysql":
+			errs = append(errs, fmt.Errorf("homer5 has only mysql support"))
+		case s.DBShema == "homer7" && s.DBDriver != "postgres":
+			errs = append(errs, fmt.Errorf("homer7 has only postgres support"))
+		}
+	}
+	if strings.Count(s.PromTargetIP, ",") != strings.Count(s.PromTargetName, ",") {
+		errs = append(errs, fmt.Errorf("please give every PromTargetIP a unique IP and PromTargetName a unique name"))
+	}
+	for _, id := range s.AlegIDs {
+		if i := strings.IndexByte(id, ','); i >= 0 {
+			if _, err := regexp.Compile(id[i+1:]); err != nil {
+				errs = append(errs, fmt.Errorf("invalid AlegIDs regex %q: %v", id[i+1:], err))
+			}
+		}
+	}
+	for _, p := range s.OutputPolicy {
+		if strings.IndexByte(p, '=') < 1 {
+			errs = append(errs, fmt.Errorf("invalid OutputPolicy %q, please use name=policy", p))
+		}
+	}
+	for _, t := range s.ConfigHTTPToken {
+		if i := strings.IndexByte(t, '='); i < 1 || i == len(t)-1 {
+			errs = append(errs, fmt.Errorf("invalid ConfigHTTPToken %q, please use name=token", maskToken(t)))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Changed returns the names of the settings which differ between a and b.
+func Changed(a, b *HeplifyServer) []string {
+	var names []string
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			names = append(names, va.Type().Field(i).Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "heplify-server.toml")
+
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`HEPAddr = "127.0.0.1:9060"` + "\n" + `DBShema = "homer7"` + "\n" + `DBDriver = "postgres"`)
+	a, err := Load(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "127.0.0.1:9060", a.HEPAddr)
+		assert.Equal(t, 400, a.DBBulk)
+	}
+
+	write(`HEPAddr = "127.0.0.1:9061"` + "\n" + `DBShema = "homer7"` + "\n" + `DBDriver = "postgres"` + "\n" + `DBDropDays = 7`)
+	b, err := Load(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"HEPAddr", "DBDropDays"}, Changed(a, b))
+	}
+
+	c, err := LoadFile(path)
+	if assert.NoError(t, err) {
+		assert.Empty(t, Changed(b, c))
+	}
+	c, err = LoadFile("")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "0.0.0.0:9060", c.HEPAddr)
+	}
+
+	for _, s := range []string{
+		`DBShema = "homer7"`,
+		`PromTargetIP = "10.0.0.1,10.0.0.2"` + "\n" + `PromTargetName = "sbc"`,
+		`AlegIDs = ["X-CID,(unclosed"]`,
+		`OutputPolicy = ["block"]`,
+		`HEPAddr = `,
+	} {
+		write(s)
+		_, err := Load(path)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "heplify-server.toml")
+
+	s := `HEPAddr = "127.0.0.1:9060"` + "\n" + `DBAdr = "localhost:5432"` + "\n" +
+		`DBAddr = "localhost:5432"` + "\n" + `DBShema = "homer7"` + "\n" + `DBDriver = "mysql"`
+	if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, errs := Check(path)
+	if assert.NotNil(t, cfg) && assert.Len(t, errs, 2) {
+		assert.Equal(t, "127.0.0.1:9060", cfg.HEPAddr)
+		assert.EqualError(t, errs[0], "unknown setting DBAdr, did you mean DBAddr?")
+		assert.EqualError(t, errs[1], "homer7 has only postgres support")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`HEPAddr = `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, errs = Check(path)
+	assert.Nil(t, cfg)
+	assert.Len(t, errs, 1)
+}
+
+func TestGet(t *testing.T) {
+	assert.True(t, Get() == &Setting)
+	cfg := &HeplifyServer{DBBulk: 200}
+	Set(cfg)
+	assert.True(t, Get() == cfg)
+	Set(nil)
+	assert.True(t, Get() == &Setting)
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// private replaces passwords and tokens in logs and the admin API.
+const private = "<private>"
+
+// secretSettings hold passwords and tokens. Token lists use name=token entries.
+var secretSettings = []string{"DBPass", "ESPass", "HEPGRPCToken", "HEPHTTPToken", "ConfigHTTPPW", "ConfigHTTPToken"}
+
+// fixedSettings are command-line only and can't be patched.
+var fixedSettings = []string{"Config", "Check", "Version"}
+
+// Masked returns a copy of s with all passwords and tokens replaced.
+func (s HeplifyServer) Masked() HeplifyServer {
+	v := reflect.ValueOf(&s).Elem()
+	for _, name := range secretSettings {
+		f := v.FieldByName(name)
+		f.Set(reflect.ValueOf(MaskedValue(name, f.Interface())))
+	}
+	return s
+}
+
+// MaskedValue replaces the password or tokens in val of the setting name.
+func MaskedValue(name string, val interface{}) interface{} {
+	if !contains(secretSettings, name) {
+		return val
+	}
+	switch v := val.(type) {
+	case string:
+		if v != "" {
+			return private
+		}
+	case []string:
+		if len(v) == 0 {
+			return v
+		}
+		masked := make([]string, len(v))
+		for i := range v {
+			masked[i] = maskToken(v[i])
+		}
+		return masked
+	}
+	return val
+}
+
+func maskToken(s string) string {
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		return s[:i+1] + private
+	}
+	return private
+}
+
+// Patch decodes the JSON values of the named settings. Masked passwords
+// and tokens keep their value in s, null resets a setting to its default.
+func (s HeplifyServer) Patch(values map[string]json.RawMessage) (map[string]interface{}, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs Errors
+	patch := make(map[string]interface{}, len(values))
+	v := reflect.ValueOf(s)
+	for _, name := range names {
+		f, ok := v.Type().FieldByName(name)
+		switch {
+		case !ok:
+			errs = append(errs, unknownKey(name))
+			continue
+		case contains(fixedSettings, name):
+			errs = append(errs, fmt.Errorf("%s can only be set on the command line", name))
+			continue
+		}
+		if string(values[name]) == "null" {
+			patch[name] = nil
+			continue
+		}
+		val := reflect.New(f.Type)
+		if err := json.Unmarshal(values[name], val.Interface()); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %v", name, err))
+			continue
+		}
+		patch[name] = val.Elem().Interface()
+		if contains(secretSettings, name) {
+			patch[name] = unmask(patch[name], v.FieldByName(name).Interface())
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return patch, nil
+}
+
+// unmask replaces masked values of val with the current ones.
+func unmask(val, cur interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		if v == private {
+			return cur
+		}
+	case []string:
+		for i, t := range v {
+			for _, c := range cur.([]string) {
+				if t == maskToken(c) {
+					v[i] = c
+				}
+			}
+		}
+	}
+	return val
+}
+
+// Update sets the values in the TOML file at path and removes the settings
+// with nil values. The other settings of the file are kept, but the file is
+// written without its comments. It is only replaced when it loads and
+// passes check.
+func Update(path string, values map[string]interface{}, check func(*HeplifyServer) error) error {
+	if !strings.HasSuffix(path, ".toml") {
+		return fmt.Errorf("can't update %s, only TOML files are supported", path)
+	}
+	mode := os.FileMode(0644)
+	tree, err := toml.TreeFromMap(map[string]interface{}{})
+	if fi, serr := os.Stat(path); serr == nil {
+		tree, err = toml.LoadFile(path)
+		mode = fi.Mode()
+	} else if !os.IsNotExist(serr) {
+		return serr
+	}
+	if err != nil {
+		return err
+	}
+	for name, val := range values {
+		if val == nil {
+			if tree.Has(name) {
+				tree.Delete(name)
+			}
+			continue
+		}
+		tree.Set(name, tomlValue(val))
+	}
+	data, err := tree.ToTomlString()
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.toml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString(data); err == nil {
+		err = f.Chmod(mode)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg, err := Load(f.Name())
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		if err := check(cfg); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// tomlValue converts ints to the int64 values of a TOML tree.
+func tomlValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case int:
+		return int64(v)
+	case []int:
+		ints := make([]int64, len(v))
+		for i := range v {
+			ints[i] = int64(v[i])
+		}
+		return ints
+	}
+	return val
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
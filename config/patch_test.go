@@ -0,0 +1,85 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatch(t *testing.T) {
+	s := HeplifyServer{DBPass: "secret", ConfigHTTPToken: []string{"ops=abc", "ci=def"}, DBBulk: 400}
+	m := s.Masked()
+	assert.Equal(t, "<private>", m.DBPass)
+	assert.Equal(t, []string{"ops=<private>", "ci=<private>"}, m.ConfigHTTPToken)
+	assert.Equal(t, "abc", s.ConfigHTTPToken[0][4:])
+	assert.Empty(t, m.ESPass)
+
+	patch, err := s.Patch(map[string]json.RawMessage{
+		"DBPass":          json.RawMessage(`"<private>"`),
+		"ConfigHTTPToken": json.RawMessage(`["ops=<private>","dev=ghi"]`),
+		"DBBulk":          json.RawMessage(`200`),
+		"ESHEPFilter":     json.RawMessage(`[1,5]`),
+		"DBUser":          json.RawMessage(`null`),
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"DBPass":          "secret",
+			"ConfigHTTPToken": []string{"ops=abc", "dev=ghi"},
+			"DBBulk":          200,
+			"ESHEPFilter":     []int{1, 5},
+			"DBUser":          nil,
+		}, patch)
+	}
+
+	_, err = s.Patch(map[string]json.RawMessage{
+		"DBBlk":    json.RawMessage(`200`),
+		"DBBuffer": json.RawMessage(`"big"`),
+		"Config":   json.RawMessage(`"/tmp/x.toml"`),
+	})
+	if errs, ok := err.(Errors); assert.True(t, ok) && assert.Len(t, errs, 3) {
+		assert.Contains(t, errs[0].Error(), "Config")
+		assert.EqualError(t, errs[1], "unknown setting DBBlk, did you mean DBBulk?")
+		assert.Contains(t, errs[2].Error(), "DBBuffer")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "heplify-server.toml")
+	s := `HEPAddr = "127.0.0.1:9060"` + "\n" + `DBShema = "homer7"` + "\n" + `DBDriver = "postgres"` + "\n" + `DBUser = "homer"`
+	if err := ioutil.WriteFile(path, []byte(s), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	err = Update(path, map[string]interface{}{"DBBulk": 200, "ESHEPFilter": []int{1, 5}, "DBUser": nil}, nil)
+	if assert.NoError(t, err) {
+		cfg, err := Load(path)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "127.0.0.1:9060", cfg.HEPAddr)
+			assert.Equal(t, 200, cfg.DBBulk)
+			assert.Equal(t, []int{1, 5}, cfg.ESHEPFilter)
+			assert.Equal(t, "root", cfg.DBUser)
+		}
+		fi, err := os.Stat(path)
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0640), fi.Mode())
+		}
+	}
+
+	// an invalid result keeps the file
+	assert.Error(t, Update(path, map[string]interface{}{"DBShema": "homer9"}, nil))
+	cfg, err := Load(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "homer7", cfg.DBShema)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
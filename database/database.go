@@ -4,16 +4,41 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sipcapture/heplify-server/config"
 	"github.com/sipcapture/heplify-server/decoder"
 	"github.com/valyala/fasttemplate"
 )
 
+var (
+	batchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "heplify_db_batch_duration_seconds",
+		Help:    "Duration of database batch inserts",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}},
+		[]string{"driver"})
+	batchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_db_batch_failures_total",
+		Help: "Failed database batch inserts"},
+		[]string{"driver"})
+)
+
+// observeBatch records the duration and the outcome of a batch insert.
+func observeBatch(driver string, start time.Time, err error) {
+	batchDuration.WithLabelValues(driver).Observe(time.Since(start).Seconds())
+	if err != nil {
+		batchFailures.WithLabelValues(driver).Inc()
+	}
+}
+
 type Database struct {
-	H    DBHandler
-	Chan chan *decoder.HEP
+	H     DBHandler
+	Chan  chan *decoder.HEP
+	spool *spool
+	quit  chan bool
 }
 
 type DBHandler interface {
@@ -29,14 +54,16 @@ func New(name string) *Database {
 	}
 
 	return &Database{
-		H: register[name],
+		H:    register[name],
+		quit: make(chan bool),
 	}
 }
 
 func (d *Database) Run() error {
-	driver := config.Setting.DBDriver
-	shema := config.Setting.DBShema
-	worker := config.Setting.DBWorker
+	cfg := config.Get()
+	driver := cfg.DBDriver
+	shema := cfg.DBShema
+	worker := cfg.DBWorker
 
 	if driver != "mock" {
 		if driver != "mysql" && driver != "postgres" {
@@ -53,6 +80,15 @@ func (d *Database) Run() error {
 		}
 	}
 
+	if sp, ok := d.H.(spooler); ok && cfg.DBSpoolDir != "" {
+		s, err := newSpool(cfg.DBSpoolDir, int64(cfg.DBSpoolMaxSize)<<20, cfg.DBBulk)
+		if err != nil {
+			return err
+		}
+		d.spool = s
+		sp.setSpool(s)
+	}
+
 	err := d.H.setup()
 	if err != nil {
 		return err
@@ -67,20 +103,105 @@ func (d *Database) Run() error {
 			d.H.insert(d.Chan)
 		}()
 	}
+
+	if d.spool != nil {
+		go d.replaySpool()
+	}
 	return nil
 }
 
 func (d *Database) End() {
+	if d.spool != nil {
+		d.quit <- true
+		<-d.quit
+		if err := d.spool.flush(); err != nil {
+			logp.Err("%v", err)
+		}
+	}
 	close(d.Chan)
-	logp.Info("close %s channel", config.Setting.DBDriver)
+	logp.Info("close %s channel", config.Get().DBDriver)
+}
+
+// pingTimeout bounds the database ping of the health checks.
+const pingTimeout = 2 * time.Second
+
+// Health pings the database when the handler supports it.
+func (d *Database) Health() error {
+	if p, ok := d.H.(interface{ ping() error }); ok {
+		return p.ping()
+	}
+	return nil
+}
+
+// Spill writes a packet which doesn't fit into the channel to the spool.
+// It returns false when no spool is configured or the spool is full.
+func (d *Database) Spill(pkt *decoder.HEP) bool {
+	if d.spool == nil {
+		return false
+	}
+	if err := d.spool.spill(pkt); err != nil {
+		logp.Warn("%v", err)
+		return false
+	}
+	return true
+}
+
+func (d *Database) replaySpool() {
+	ticker := time.NewTicker(time.Duration(config.Get().DBTimer+1) * time.Second)
+	defer ticker.Stop()
+	sp := d.H.(spooler)
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.spool.flush(); err != nil {
+				logp.Warn("%v", err)
+			}
+			if err := sp.ping(); err != nil {
+				continue
+			}
+			stopped := false
+			err := d.spool.replay(func(r *spoolRecord) error {
+				if len(r.HEP) == 0 {
+					return sp.replay(r)
+				}
+				for len(r.HEP) > 0 {
+					pkt, err := decodePacket(r.HEP[0])
+					if err != nil {
+						logp.Warn("drop spooled packet: %v", err)
+					} else {
+						select {
+						case d.Chan <- pkt:
+						case <-d.quit:
+							stopped = true
+							return fmt.Errorf("stop replay of database spool")
+						}
+					}
+					r.HEP = r.HEP[1:]
+				}
+				return nil
+			})
+			if err != nil {
+				logp.Warn("%v", err)
+			}
+			if stopped {
+				d.quit <- true
+				return
+			}
+		case <-d.quit:
+			d.quit <- true
+			return
+		}
+	}
 }
 
 func ConnectString(dbName string) (string, error) {
+	cfg := config.Get()
 	var dsn string
-	driver := config.Setting.DBDriver
-	addr := strings.Split(config.Setting.DBAddr, ":")
+	driver := cfg.DBDriver
+	addr := strings.Split(cfg.DBAddr, ":")
 	if len(addr) != 2 {
-		return "", fmt.Errorf("wrong database connection format: %v, it should be localhost:3306", config.Setting.DBAddr)
+		return "", fmt.Errorf("wrong database connection format: %v, it should be localhost:3306", cfg.DBAddr)
 	}
 	if (addr[1] == "3306" && driver == "postgres") ||
 		addr[1] == "5432" && driver == "mysql" {
@@ -90,12 +211,12 @@ func ConnectString(dbName string) (string, error) {
 	if driver == "mysql" {
 		if addr[0] == "unix" {
 			// user:password@unix(/tmp/mysql.sock)/dbname?loc=Local
-			dsn = config.Setting.DBUser + ":" + config.Setting.DBPass +
+			dsn = cfg.DBUser + ":" + cfg.DBPass +
 				"@unix(" + addr[1] + ")/" + dbName +
 				"?collation=utf8mb4_unicode_ci&parseTime=true"
 		} else {
 			// user:password@tcp(localhost:5555)/dbname?tls=skip-verify&autocommit=true
-			dsn = config.Setting.DBUser + ":" + config.Setting.DBPass +
+			dsn = cfg.DBUser + ":" + cfg.DBPass +
 				"@tcp(" + addr[0] + ":" + addr[1] + ")/" + dbName +
 				"?collation=utf8mb4_unicode_ci&parseTime=true"
 		}
@@ -111,15 +232,15 @@ func ConnectString(dbName string) (string, error) {
 			" host=" + addr[0] +
 			" port=" + addr[1] +
 			" dbname=" + dbName +
-			" user=" + config.Setting.DBUser +
-			" password=" + config.Setting.DBPass
+			" user=" + cfg.DBUser +
+			" password=" + cfg.DBPass
 	}
 	return dsn, nil
 }
 
 func buildTemplate() *fasttemplate.Template {
 	var dataTemplate string
-	sh := config.Setting.SIPHeader
+	sh := config.Get().SIPHeader
 	if len(sh) < 1 {
 		sh = []string{"ruri_user", "ruri_domain", "from_user", "from_tag", "to_user", "callid", "cseq", "method", "user_agent"}
 	}
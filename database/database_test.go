@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/sipcapture/heplify-server/config"
@@ -83,3 +84,15 @@ func BenchmarkMakeISUPDataHeader(b *testing.B) {
 	}
 }
 */
+
+func TestHeaders(t *testing.T) {
+	pHeader, dHeader := Headers(hep)
+	for _, want := range []string{`"srcIp":"192.168.247.250"`, `"payloadType":1`, `"correlation_id":"te\"st\""`} {
+		if !strings.Contains(pHeader, want) {
+			t.Errorf("protocol_header %s misses %s", pHeader, want)
+		}
+	}
+	if !strings.HasPrefix(dHeader, `{"ruri_user":`) || !strings.Contains(dHeader, `"callid":"te\"st\""`) {
+		t.Errorf("unexpected data_header %s", dHeader)
+	}
+}
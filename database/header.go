@@ -10,6 +10,24 @@ import (
 	"github.com/valyala/fasttemplate"
 )
 
+// Headers returns the protocol_header and data_header JSON the postgres handler
+// stores for a packet. The data_header is empty for packets which aren't stored.
+func Headers(h *decoder.HEP) (protoHeader, dataHeader string) {
+	bb := bytebufferpool.Get()
+	defer bytebufferpool.Put(bb)
+
+	protoHeader = makeProtoHeader(h, bb)
+	switch {
+	case h.ProtoType == 1 && h.Payload != "" && h.SIP != nil:
+		dataHeader = makeSIPDataHeader(h, bb, buildTemplate())
+	case h.ProtoType == 54 && h.Payload != "":
+		_, dataHeader = makeISUPDataHeader([]byte(h.Payload), bb)
+	case h.ProtoType >= 2 && h.Payload != "" && h.CID != "":
+		dataHeader = makeRTCDataHeader(h, bb)
+	}
+	return protoHeader, dataHeader
+}
+
 func makeProtoHeader(h *decoder.HEP, bb *bytebufferpool.ByteBuffer) string {
 	bb.Reset()
 	bb.WriteString(`{`)
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -88,15 +89,17 @@ type MySQL struct {
 	dbTimer    time.Duration
 	sipBulkVal []byte
 	rtcBulkVal []byte
+	spool      *spool
 }
 
 func (m *MySQL) setup() error {
-	cs, err := ConnectString(config.Setting.DBDataTable)
+	cfg := config.Get()
+	cs, err := ConnectString(cfg.DBDataTable)
 	if err != nil {
 		return err
 	}
 
-	if m.db, err = sql.Open(config.Setting.DBDriver, cs); err != nil {
+	if m.db, err = sql.Open(cfg.DBDriver, cs); err != nil {
 		m.db.Close()
 		return err
 	}
@@ -106,19 +109,19 @@ func (m *MySQL) setup() error {
 		return err
 	}
 
-	m.db.SetMaxOpenConns(config.Setting.DBWorker * 4)
-	m.db.SetMaxIdleConns(config.Setting.DBWorker)
+	m.db.SetMaxOpenConns(cfg.DBWorker * 4)
+	m.db.SetMaxIdleConns(cfg.DBWorker)
 
-	m.bulkCnt = config.Setting.DBBulk
+	m.bulkCnt = cfg.DBBulk
 	if m.bulkCnt < 1 {
 		m.bulkCnt = 1
 	}
-	m.dbTimer = time.Duration(config.Setting.DBTimer) * time.Second
+	m.dbTimer = time.Duration(cfg.DBTimer) * time.Second
 
 	m.sipBulkVal = sipQueryVal(m.bulkCnt)
 	m.rtcBulkVal = rtcQueryVal(m.bulkCnt)
 
-	logp.Info("%s connection established\n", config.Setting.DBDriver)
+	logp.Info("%s connection established\n", cfg.DBDriver)
 	return nil
 }
 
@@ -332,12 +335,32 @@ func (m *MySQL) bulkInsert(q, v []byte, rows []interface{}) {
 	query := make([]byte, len(tblDate)+len(v))
 	tdl := copy(query, tblDate)
 	copy(query[tdl:], v)
+	start := time.Now()
 	_, err := m.db.Exec(string(query), rows...)
+	observeBatch("mysql", start, err)
 	if err != nil {
 		logp.Err("%v", err)
+		if m.spool != nil {
+			if err = m.spool.write(&spoolRecord{Query: string(query), Args: rows}); err != nil {
+				logp.Err("%v", err)
+			}
+		}
 	}
 }
 
+func (m *MySQL) setSpool(s *spool) { m.spool = s }
+
+func (m *MySQL) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	return m.db.PingContext(ctx)
+}
+
+func (m *MySQL) replay(r *spoolRecord) error {
+	_, err := m.db.Exec(r.Query, r.Args...)
+	return err
+}
+
 func short(s string, i int) string {
 	if len(s) > i {
 		return s[:i]
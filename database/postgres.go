@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -16,6 +17,7 @@ type Postgres struct {
 	dbTimer         time.Duration
 	bulkCnt         int
 	forceHEPPayload []int
+	spool           *spool
 }
 
 const (
@@ -30,12 +32,13 @@ const (
 )
 
 func (p *Postgres) setup() error {
-	cs, err := ConnectString(config.Setting.DBDataTable)
+	cfg := config.Get()
+	cs, err := ConnectString(cfg.DBDataTable)
 	if err != nil {
 		return err
 	}
 
-	if p.db, err = sql.Open(config.Setting.DBDriver, cs); err != nil {
+	if p.db, err = sql.Open(cfg.DBDriver, cs); err != nil {
 		p.db.Close()
 		return err
 	}
@@ -45,20 +48,20 @@ func (p *Postgres) setup() error {
 		return err
 	}
 
-	p.db.SetMaxOpenConns(config.Setting.DBWorker * 4)
-	p.db.SetMaxIdleConns(config.Setting.DBWorker)
+	p.db.SetMaxOpenConns(cfg.DBWorker * 4)
+	p.db.SetMaxIdleConns(cfg.DBWorker)
 
-	p.bulkCnt = config.Setting.DBBulk
+	p.bulkCnt = cfg.DBBulk
 
 	/* force JSON payload to data header */
-	p.forceHEPPayload = config.Setting.ForceHEPPayload
+	p.forceHEPPayload = cfg.ForceHEPPayload
 
 	if p.bulkCnt < 1 {
 		p.bulkCnt = 1
 	}
-	p.dbTimer = time.Duration(config.Setting.DBTimer) * time.Second
+	p.dbTimer = time.Duration(cfg.DBTimer) * time.Second
 
-	logp.Info("%s connection established\n", config.Setting.DBDriver)
+	logp.Info("%s connection established\n", cfg.DBDriver)
 	return nil
 }
 
@@ -254,20 +257,32 @@ func (p *Postgres) insert(hCh chan *decoder.HEP) {
 }
 
 func (p *Postgres) bulkInsert(query string, rows []string) {
+	start := time.Now()
+	err := p.copyRows(query, rows)
+	observeBatch("postgres", start, err)
+	if err == nil {
+		return
+	}
+	logp.Err("%v", err)
+	if p.spool != nil {
+		if err = p.spool.write(&spoolRecord{Query: query, Rows: rows}); err != nil {
+			logp.Err("%v", err)
+		}
+	}
+}
+
+func (p *Postgres) copyRows(query string, rows []string) error {
 	tx, err := p.db.Begin()
 	if err != nil || tx == nil {
-		logp.Err("%v", err)
-		return
+		return err
 	}
 
 	stmt, err := tx.Prepare(query)
 	if err != nil {
-		logp.Err("%v", err)
-		err := tx.Rollback()
-		if err != nil {
+		if err := tx.Rollback(); err != nil {
 			logp.Err("%v", err)
 		}
-		return
+		return err
 	}
 
 	for i := 0; i < len(rows); i = i + 5 {
@@ -280,7 +295,11 @@ func (p *Postgres) bulkInsert(query string, rows []string) {
 
 	_, err = stmt.Exec()
 	if err != nil {
-		logp.Err("%v", err)
+		stmt.Close()
+		if err := tx.Rollback(); err != nil {
+			logp.Err("%v", err)
+		}
+		return err
 	}
 	err = stmt.Close()
 	if err != nil {
@@ -288,8 +307,19 @@ func (p *Postgres) bulkInsert(query string, rows []string) {
 	}
 	err = tx.Commit()
 	if err != nil {
-		logp.Err("%v", err)
+		return err
 	}
 
 	logp.Debug("sql", "%s\n\n%v\n\n", query, rows)
+	return nil
+}
+
+func (p *Postgres) setSpool(s *spool) { p.spool = s }
+
+func (p *Postgres) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	return p.db.PingContext(ctx)
 }
+
+func (p *Postgres) replay(r *spoolRecord) error { return p.copyRows(r.Query, r.Rows) }
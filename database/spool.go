@@ -0,0 +1,259 @@
+package database
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+var (
+	spoolSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "heplify_db_spool_bytes",
+		Help: "Size of the database spool on disk"})
+	spoolBatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_db_spool_batches_total",
+		Help: "Database spool batches by action"},
+		[]string{"action"})
+)
+
+const spoolExt = ".spool"
+
+// spoolRecord is a batch which could not be written to the database.
+// It holds either a failed query with its rows or overflowing HEP packets.
+type spoolRecord struct {
+	Query string
+	Args  []interface{} // MySQL values
+	Rows  []string      // Postgres COPY rows
+	HEP   [][]byte      // gob encoded decoder.HEP packets
+}
+
+// encodePacket keeps the decoded packet with the changes of scripts and the
+// NodeName of the TLS certificate. Decoding it again would run dedup,
+// DiscardMethod and CensorMethod a second time.
+func encodePacket(pkt *decoder.HEP) ([]byte, error) {
+	cp := *pkt
+	cp.Raw = nil
+	if pkt.SIP != nil {
+		sip := *pkt.SIP
+		// errors are not registered with gob and not stored
+		sip.Error = nil
+		cp.SIP = &sip
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&cp); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodePacket(b []byte) (*decoder.HEP, error) {
+	pkt := &decoder.HEP{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(pkt); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// spooler is implemented by handlers which can spool failed batches
+// and replay them once the database is reachable again.
+type spooler interface {
+	setSpool(*spool)
+	ping() error
+	replay(*spoolRecord) error
+}
+
+// spool is a directory based write-ahead log. Every batch is
+// written to its own file, named by a sequence number to keep the order.
+type spool struct {
+	dir     string
+	maxSize int64
+	bulk    int
+
+	mu      sync.Mutex
+	size    int64
+	seq     uint64
+	pending [][]byte
+}
+
+func newSpool(dir string, maxSize int64, bulk int) (*spool, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	s := &spool{dir: dir, maxSize: maxSize, bulk: bulk}
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		s.size += fi.Size()
+		seq, _ := strconv.ParseUint(strings.TrimSuffix(filepath.Base(f), spoolExt), 10, 64)
+		if seq >= s.seq {
+			s.seq = seq + 1
+		}
+	}
+	spoolSize.Set(float64(s.size))
+	if len(files) > 0 {
+		logp.Info("found %d batches with %d bytes in database spool %s", len(files), s.size, dir)
+	}
+	return s, nil
+}
+
+// files returns the spool files in write order.
+func (s *spool) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+spoolExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *spool) write(r *spoolRecord) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxSize > 0 && s.size+int64(buf.Len()) > s.maxSize {
+		spoolBatches.WithLabelValues("dropped").Inc()
+		return fmt.Errorf("database spool %s is full with %d bytes", s.dir, s.size)
+	}
+
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.seq, spoolExt))
+	if err := writeFile(name, buf.Bytes()); err != nil {
+		return err
+	}
+	s.seq++
+	s.size += int64(buf.Len())
+	spoolSize.Set(float64(s.size))
+	spoolBatches.WithLabelValues("spooled").Inc()
+	return nil
+}
+
+// writeFile replaces name atomically with data.
+func writeFile(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// spill buffers an overflowing packet and writes a batch of them to disk.
+func (s *spool) spill(pkt *decoder.HEP) error {
+	b, err := encodePacket(pkt)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.pending = append(s.pending, b)
+	if len(s.pending) < s.bulk {
+		s.mu.Unlock()
+		return nil
+	}
+	r := &spoolRecord{HEP: s.pending}
+	s.pending = nil
+	s.mu.Unlock()
+	return s.write(r)
+}
+
+// flush writes the buffered overflowing packets to disk.
+func (s *spool) flush() error {
+	s.mu.Lock()
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	r := &spoolRecord{HEP: s.pending}
+	s.pending = nil
+	s.mu.Unlock()
+	return s.write(r)
+}
+
+// replay hands the spooled batches in order to exec and removes them on
+// success. It stops at the first failed batch to keep the order. When exec
+// fails after it consumed some packets of r.HEP it has to leave only the
+// remaining ones in r.HEP, the file is rewritten with them.
+func (s *spool) replay(exec func(*spoolRecord) error) error {
+	files, err := s.files()
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	replayed := 0
+	defer func() {
+		if replayed > 0 {
+			logp.Info("replayed %d batches from database spool %s", replayed, s.dir)
+		}
+	}()
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		r := &spoolRecord{}
+		if err = gob.NewDecoder(bytes.NewReader(b)).Decode(r); err != nil {
+			logp.Err("drop corrupt database spool file %s: %v", f, err)
+			spoolBatches.WithLabelValues("dropped").Inc()
+		} else {
+			n := len(r.HEP)
+			if err = exec(r); err != nil {
+				if len(r.HEP) < n {
+					if rerr := s.rewrite(f, int64(len(b)), r); rerr != nil {
+						logp.Err("%v", rerr)
+					}
+				}
+				return err
+			}
+			replayed++
+			spoolBatches.WithLabelValues("replayed").Inc()
+		}
+		if err = os.Remove(f); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.size -= int64(len(b))
+		spoolSize.Set(float64(s.size))
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// rewrite replaces the partly replayed file f of size with r.
+func (s *spool) rewrite(f string, size int64, r *spoolRecord) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return err
+	}
+	if err := writeFile(f, buf.Bytes()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.size += int64(buf.Len()) - size
+	spoolSize.Set(float64(s.size))
+	s.mu.Unlock()
+	return nil
+}
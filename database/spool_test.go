@@ -0,0 +1,98 @@
+package database
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newSpool(dir, 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, s.write(&spoolRecord{Query: "q1", Args: []interface{}{"a", int64(1), uint32(2)}}))
+	assert.NoError(t, s.write(&spoolRecord{Query: "q2", Rows: []string{"b"}}))
+	assert.NoError(t, s.spill(hep))
+	assert.NoError(t, s.flush())
+
+	// a new spool continues with the existing files
+	s, err = newSpool(dir, 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(3), s.seq)
+
+	var got []string
+	fail := true
+	replay := func(r *spoolRecord) error {
+		if r.Query == "q2" && fail {
+			fail = false
+			return errors.New("db down")
+		}
+		got = append(got, r.Query)
+		return nil
+	}
+	assert.Error(t, s.replay(replay))
+	assert.Equal(t, []string{"q1"}, got)
+	assert.NoError(t, s.replay(replay))
+	assert.Equal(t, []string{"q1", "q2", ""}, got)
+	assert.Equal(t, int64(0), s.size)
+
+	// spilled packets keep the changes after decoding
+	pkt := *hep
+	pkt.NodeName = "sbc-cert"
+	sip := *hep.SIP
+	sip.CallID = "changed-by-script"
+	pkt.SIP = &sip
+	s.bulk = 3
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.spill(&pkt))
+	}
+	var pkts []*decoder.HEP
+	stop := errors.New("stop")
+	replay = func(r *spoolRecord) error {
+		for len(r.HEP) > 0 {
+			if len(pkts) == 1 {
+				return stop
+			}
+			p, err := decodePacket(r.HEP[0])
+			if assert.NoError(t, err) {
+				pkts = append(pkts, p)
+			}
+			r.HEP = r.HEP[1:]
+		}
+		return nil
+	}
+	// an interrupted replay keeps only the remaining packets
+	assert.Equal(t, stop, s.replay(replay))
+	pkts = pkts[:0]
+	assert.NoError(t, s.replay(func(r *spoolRecord) error {
+		assert.Len(t, r.HEP, 2)
+		return nil
+	}))
+	assert.Equal(t, int64(0), s.size)
+
+	s.bulk = 1
+	assert.NoError(t, s.spill(&pkt))
+	assert.NoError(t, s.replay(replay))
+	if assert.Len(t, pkts, 1) {
+		assert.Equal(t, "sbc-cert", pkts[0].NodeName)
+		assert.Equal(t, "changed-by-script", pkts[0].SIP.CallID)
+		assert.Equal(t, hep.Payload, pkts[0].Payload)
+		assert.True(t, hep.Timestamp.Equal(pkts[0].Timestamp))
+	}
+
+	s.maxSize = 10
+	assert.Error(t, s.write(&spoolRecord{Query: "too big"}))
+}
@@ -0,0 +1,72 @@
+package decoder
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/sipcapture/heplify-server/config"
+)
+
+func isGzip(b []byte) bool {
+	return len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b
+}
+
+func isZlib(b []byte) bool {
+	// CMF must be deflate with a window <= 32K and the header checksum must match
+	return len(b) > 2 && b[0]&0x0f == 8 && b[0]>>4 <= 7 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// inflatePayload decompresses the payload when it was sent inside the
+// compressed payload chunk or when PayloadCompression is set. In auto mode
+// a plain payload which only starts like gzip or zlib is kept as it is.
+func (h *HEP) inflatePayload() error {
+	method := strings.ToLower(config.Get().PayloadCompression)
+	if !h.compressed && method == "" {
+		return nil
+	}
+	guess := !h.compressed && method == "auto"
+
+	b := []byte(h.Payload)
+	var r io.Reader
+	var err error
+	switch {
+	case isGzip(b) && (h.compressed || method == "gzip" || method == "auto"):
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case isZlib(b) && (h.compressed || method == "zlib" || method == "auto"):
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	case h.compressed:
+		r = flate.NewReader(bytes.NewReader(b))
+	default:
+		return nil
+	}
+	if err != nil {
+		if guess {
+			return nil
+		}
+		return &decodeError{ClassCompression, fmt.Sprintf("can't inflate payload from nodeID %d: %v", h.NodeID, err)}
+	}
+
+	limit := int64(config.Get().PayloadMaxInflate)
+	if limit < 1 {
+		limit = 1 << 20
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		if guess {
+			return nil
+		}
+		return &decodeError{ClassCompression, fmt.Sprintf("can't inflate payload from nodeID %d: %v", h.NodeID, err)}
+	}
+	if int64(len(out)) > limit {
+		return &decodeError{ClassCompression, fmt.Sprintf("inflated payload from nodeID %d exceeds %d byte", h.NodeID, limit)}
+	}
+	h.Payload = string(out)
+	h.compressed = false
+	return nil
+}
@@ -0,0 +1,57 @@
+package decoder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"strings"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInflatePayload(t *testing.T) {
+	payload := strings.Repeat("INVITE sip:bob@example.com SIP/2.0\r\n", 10)
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(payload))
+	gw.Close()
+
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(payload))
+	zw.Close()
+
+	h := &HEP{Payload: gz.String(), compressed: true}
+	assert.NoError(t, h.inflatePayload())
+	assert.Equal(t, payload, h.Payload)
+
+	// without the compressed chunk only PayloadCompression triggers inflation
+	h = &HEP{Payload: zl.String()}
+	assert.NoError(t, h.inflatePayload())
+	assert.Equal(t, zl.String(), h.Payload)
+
+	config.Setting.PayloadCompression = "auto"
+	defer func() { config.Setting.PayloadCompression = "" }()
+	h = &HEP{Payload: zl.String()}
+	assert.NoError(t, h.inflatePayload())
+	assert.Equal(t, payload, h.Payload)
+
+	h = &HEP{Payload: payload}
+	assert.NoError(t, h.inflatePayload())
+	assert.Equal(t, payload, h.Payload)
+
+	// plain payloads which start like zlib stay untouched
+	h = &HEP{Payload: "x^2 exceeds the limit"}
+	assert.NoError(t, h.inflatePayload())
+	assert.Equal(t, "x^2 exceeds the limit", h.Payload)
+	h = &HEP{Payload: "x^2 exceeds the limit", compressed: true}
+	assert.Error(t, h.inflatePayload())
+
+	config.Setting.PayloadMaxInflate = 100
+	defer func() { config.Setting.PayloadMaxInflate = 0 }()
+	h = &HEP{Payload: gz.String()}
+	assert.Error(t, h.inflatePayload())
+}
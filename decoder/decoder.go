@@ -1,7 +1,6 @@
 package decoder
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -56,11 +55,26 @@ const (
 	Tmsec     = 10 // Chunk 0x000a Unix timestamp, microseconds
 	ProtoType = 11 // Chunk 0x000b Protocol type (DNS, LOG, RTCP, SIP)
 	NodeID    = 12 // Chunk 0x000c Capture client ID
+	KeepAlive = 13 // Chunk 0x000d Keep alive timer
 	NodePW    = 14 // Chunk 0x000e Authentication key (plain text / TLS connection)
 	Payload   = 15 // Chunk 0x000f Captured packet payload
+	CPayload  = 16 // Chunk 0x0010 Captured compressed payload (gzip/inflate)
 	CID       = 17 // Chunk 0x0011 Correlation ID
 	Vlan      = 18 // Chunk 0x0012 VLAN
 	NodeName  = 19 // Chunk 0x0013 NodeName
+	SrcMAC    = 20 // Chunk 0x0014 Source MAC address
+	DstMAC    = 21 // Chunk 0x0015 Destination MAC address
+	EthType   = 22 // Chunk 0x0016 Ethernet type
+	TCPFlag   = 23 // Chunk 0x0017 IP TCP flag
+	TOS       = 24 // Chunk 0x0018 IP TOS
+	MOS       = 32 // Chunk 0x0020 MOS value
+	RFactor   = 33 // Chunk 0x0021 R-factor
+	GeoLoc    = 34 // Chunk 0x0022 GEO location
+	Jitter    = 35 // Chunk 0x0023 Jitter
+	TransType = 36 // Chunk 0x0024 Transaction type [call, registration]
+	JSONKeys  = 37 // Chunk 0x0025 Payload JSON keys
+	Tags      = 38 // Chunk 0x0026 Tags values
+	TagType   = 39 // Chunk 0x0027 Type of tag
 )
 
 // HEP represents HEP packet
@@ -85,6 +99,23 @@ type HEP struct {
 	NodeName    string
 	TargetName  string
 	SID         string
+	KeepAlive   uint32            `json:",omitempty"`
+	SrcMAC      string            `json:",omitempty"`
+	DstMAC      string            `json:",omitempty"`
+	EthType     uint32            `json:",omitempty"`
+	TCPFlag     uint32            `json:",omitempty"`
+	TOS         uint32            `json:",omitempty"`
+	MOS         uint32            `json:",omitempty"`
+	RFactor     uint32            `json:",omitempty"`
+	GeoLoc      string            `json:",omitempty"`
+	Jitter      uint32            `json:",omitempty"`
+	TransType   string            `json:",omitempty"`
+	JSONKeys    string            `json:",omitempty"`
+	Tags        string            `json:",omitempty"`
+	TagType     uint32            `json:",omitempty"`
+	Chunks      map[string]string `json:",omitempty"` // vendor or unknown chunks keyed by "vendorID:chunkType"
+	Raw         []byte            `json:"-"`
+	compressed  bool
 }
 
 // DecodeHEP returns a parsed HEP message
@@ -98,13 +129,20 @@ func DecodeHEP(packet []byte) (*HEP, error) {
 }
 
 func (h *HEP) parse(packet []byte) error {
+	cfg := config.Get()
 	var err error
-	if bytes.HasPrefix(packet, []byte{0x48, 0x45, 0x50, 0x33}) {
+	if IsHEP3(packet) {
 		err = h.parseHEP(packet)
 		if err != nil {
 			logp.Warn("%v", err)
 			return err
 		}
+	} else if IsLegacyHEP(packet) {
+		err = h.parseLegacyHEP(packet)
+		if err != nil {
+			logp.Warn("%v", err)
+			return err
+		}
 	} else {
 		err = h.Unmarshal(packet)
 		if err != nil {
@@ -121,6 +159,11 @@ func (h *HEP) parse(packet []byte) error {
 		h.Timestamp = t
 	}
 
+	if err = h.inflatePayload(); err != nil {
+		logp.Warn("%v", err)
+		return err
+	}
+
 	h.normPayload()
 	if h.ProtoType == 0 {
 		return nil
@@ -134,7 +177,7 @@ func (h *HEP) parse(packet []byte) error {
 			return err
 		}
 
-		for _, m := range config.Setting.CensorMethod {
+		for _, m := range cfg.CensorMethod {
 			if m == h.SIP.CseqMethod {
 				lb := len(h.SIP.Body)
 				h.SIP.Body = strings.Repeat("x", lb)
@@ -142,9 +185,9 @@ func (h *HEP) parse(packet []byte) error {
 			}
 		}
 
-		if len(config.Setting.DiscardMethod) > 0 {
-			for k := range config.Setting.DiscardMethod {
-				if config.Setting.DiscardMethod[k] == h.SIP.CseqMethod {
+		if len(cfg.DiscardMethod) > 0 {
+			for k := range cfg.DiscardMethod {
+				if cfg.DiscardMethod[k] == h.SIP.CseqMethod {
 					h.ProtoType = 0
 					return nil
 				}
@@ -161,7 +204,7 @@ func (h *HEP) parse(packet []byte) error {
 }
 
 func (h *HEP) normPayload() {
-	if config.Setting.Dedup {
+	if config.Get().Dedup {
 		ts := uint64(h.Timestamp.UnixNano())
 		kh := make([]byte, 8)
 		ks := xxhash.Sum64String(h.Payload)
@@ -2,6 +2,7 @@ package decoder
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"net"
 	"testing"
@@ -12,12 +13,6 @@ import (
 var (
 	hepPacket = []byte{0x48, 0x45, 0x50, 0x33, 0x3, 0xa, 0x0, 0x0, 0x0, 0x1, 0x0, 0x7, 0x2, 0x0, 0x0, 0x0, 0x2, 0x0, 0x7, 0x11, 0x0, 0x0, 0x0, 0x3, 0x0, 0xa, 0xc0, 0xa8, 0xf7, 0xfa, 0x0, 0x0, 0x0, 0x4, 0x0, 0xa, 0xc0, 0xa8, 0xf5, 0xfa, 0x0, 0x0, 0x0, 0x7, 0x0, 0x8, 0x13, 0xc4, 0x0, 0x0, 0x0, 0x8, 0x0, 0x8, 0x13, 0xc4, 0x0, 0x0, 0x0, 0x9, 0x0, 0xa, 0x5a, 0xa2, 0x9b, 0x98, 0x0, 0x0, 0x0, 0xa, 0x0, 0xa, 0x0, 0x1, 0xd2, 0xf4, 0x0, 0x0, 0x0, 0xb, 0x0, 0x7, 0x1, 0x0, 0x0, 0x0, 0xc, 0x0, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xe, 0x0, 0x6, 0x0, 0x0, 0x0, 0xf, 0x2, 0xa7, 0x53, 0x49, 0x50, 0x2f, 0x32, 0x2e, 0x30, 0x20, 0x32, 0x30, 0x30, 0x20, 0x4f, 0x4b, 0xd, 0xa, 0x43, 0x61, 0x6c, 0x6c, 0x2d, 0x49, 0x44, 0x3a, 0x20, 0x42, 0x43, 0x30, 0x39, 0x39, 0x38, 0x38, 0x34, 0x40, 0x36, 0x64, 0x66, 0x63, 0x66, 0x66, 0x65, 0x38, 0xd, 0xa, 0x43, 0x53, 0x65, 0x71, 0x3a, 0x20, 0x32, 0x31, 0x35, 0x38, 0x33, 0x34, 0x34, 0x38, 0x39, 0x20, 0x4f, 0x50, 0x54, 0x49, 0x4f, 0x4e, 0x53, 0xd, 0xa, 0x46, 0x72, 0x6f, 0x6d, 0x3a, 0x20, 0x3c, 0x73, 0x69, 0x70, 0x3a, 0x31, 0x39, 0x32, 0x2e, 0x31, 0x36, 0x38, 0x2e, 0x31, 0x31, 0x31, 0x2e, 0x31, 0x31, 0x31, 0x3a, 0x35, 0x30, 0x36, 0x30, 0x3e, 0x3b, 0x74, 0x61, 0x67, 0x3d, 0x36, 0x64, 0x66, 0x63, 0x66, 0x66, 0x65, 0x38, 0x2b, 0x31, 0x2b, 0x62, 0x30, 0x61, 0x39, 0x30, 0x30, 0x30, 0x33, 0x2b, 0x63, 0x39, 0x65, 0x66, 0x63, 0x32, 0x30, 0x62, 0xd, 0xa, 0x54, 0x6f, 0x3a, 0x20, 0x3c, 0x73, 0x69, 0x70, 0x3a, 0x31, 0x39, 0x32, 0x2e, 0x31, 0x36, 0x38, 0x2e, 0x31, 0x31, 0x31, 0x2e, 0x31, 0x31, 0x31, 0x3a, 0x35, 0x30, 0x36, 0x30, 0x3b, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x3d, 0x75, 0x64, 0x70, 0x3e, 0x3b, 0x74, 0x61, 0x67, 0x3d, 0x31, 0x38, 0x30, 0x34, 0x61, 0x34, 0x37, 0x64, 0x2b, 0x31, 0x2b, 0x65, 0x31, 0x30, 0x35, 0x30, 0x34, 0x37, 0x30, 0x2b, 0x62, 0x31, 0x32, 0x38, 0x61, 0x35, 0x36, 0x39, 0xd, 0xa, 0x56, 0x69, 0x61, 0x3a, 0x20, 0x53, 0x49, 0x50, 0x2f, 0x32, 0x2e, 0x30, 0x2f, 0x55, 0x44, 0x50, 0x20, 0x31, 0x39, 0x32, 0x2e, 0x31, 0x36, 0x38, 0x2e, 0x31, 0x31, 0x31, 0x2e, 0x31, 0x31, 0x31, 0x3a, 0x35, 0x30, 0x36, 0x30, 0x3b, 0x62, 0x72, 0x61, 0x6e, 0x63, 0x68, 0x3d, 0x7a, 0x39, 0x68, 0x47, 0x34, 0x62, 0x4b, 0x2b, 0x32, 0x31, 0x66, 0x31, 0x31, 0x33, 0x65, 0x37, 0x65, 0x33, 0x64, 0x30, 0x34, 0x63, 0x38, 0x34, 0x36, 0x31, 0x34, 0x38, 0x61, 0x39, 0x61, 0x64, 0x37, 0x36, 0x30, 0x37, 0x61, 0x65, 0x66, 0x61, 0x31, 0x2b, 0x36, 0x64, 0x66, 0x63, 0x66, 0x66, 0x65, 0x38, 0x2b, 0x31, 0xd, 0xa, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x3a, 0x20, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0xd, 0xa, 0x43, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x2d, 0x4c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x3a, 0x20, 0x37, 0x38, 0xd, 0xa, 0x43, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x2d, 0x54, 0x79, 0x70, 0x65, 0x3a, 0x20, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x73, 0x64, 0x70, 0xd, 0xa, 0x53, 0x75, 0x70, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x64, 0x3a, 0x20, 0x31, 0x30, 0x30, 0x72, 0x65, 0x6c, 0x2c, 0x20, 0x74, 0x69, 0x6d, 0x65, 0x72, 0xd, 0xa, 0x41, 0x63, 0x63, 0x65, 0x70, 0x74, 0x2d, 0x4c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x3a, 0x20, 0x65, 0x6e, 0xd, 0xa, 0x41, 0x63, 0x63, 0x65, 0x70, 0x74, 0x2d, 0x45, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x3a, 0x20, 0x69, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0xd, 0xa, 0x41, 0x63, 0x63, 0x65, 0x70, 0x74, 0x3a, 0x20, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x73, 0x64, 0x70, 0x2c, 0x20, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x69, 0x73, 0x75, 0x70, 0x2c, 0x20, 0x6d, 0x75, 0x6c, 0x74, 0x69, 0x70, 0x61, 0x72, 0x74, 0x2f, 0x6d, 0x69, 0x78, 0x65, 0x64, 0xd, 0xa, 0x41, 0x6c, 0x6c, 0x6f, 0x77, 0x3a, 0x20, 0x49, 0x4e, 0x56, 0x49, 0x54, 0x45, 0x2c, 0x20, 0x41, 0x43, 0x4b, 0x2c, 0x20, 0x43, 0x41, 0x4e, 0x43, 0x45, 0x4c, 0x2c, 0x20, 0x42, 0x59, 0x45, 0x2c, 0x20, 0x4f, 0x50, 0x54, 0x49, 0x4f, 0x4e, 0x53, 0x2c, 0x20, 0x4e, 0x4f, 0x54, 0x49, 0x46, 0x59, 0x2c, 0x20, 0x50, 0x52, 0x41, 0x43, 0x4b, 0x2c, 0x20, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x2c, 0x20, 0x49, 0x4e, 0x46, 0x4f, 0x2c, 0x20, 0x52, 0x45, 0x46, 0x45, 0x52, 0xd, 0xa, 0xd, 0xa, 0x76, 0x3d, 0x30, 0xd, 0xa, 0x6f, 0x3d, 0x2d, 0x20, 0x30, 0x20, 0x30, 0x20, 0x49, 0x4e, 0x20, 0x49, 0x50, 0x34, 0x20, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0xd, 0xa, 0x73, 0x3d, 0x2d, 0xd, 0xa, 0x63, 0x3d, 0x49, 0x4e, 0x20, 0x49, 0x50, 0x34, 0x20, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0xd, 0xa, 0x74, 0x3d, 0x30, 0x20, 0x30, 0xd, 0xa, 0x6d, 0x3d, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x20, 0x30, 0x20, 0x52, 0x54, 0x50, 0x2f, 0x41, 0x56, 0x50, 0x20, 0x38}
 	//rawPacket = []byte{0x0, 0xa, 0xa0, 0x0, 0xbe, 0xa8, 0x0, 0x26, 0x52, 0xe, 0xd3, 0x41, 0x8, 0x0, 0x45, 0x0, 0x2, 0xbd, 0xa1, 0xc3, 0x0, 0x0, 0x3e, 0x11, 0x69, 0x26, 0xc0, 0xa8, 0xf7, 0xfa, 0xc0, 0xa8, 0xf5, 0xfa, 0x13, 0xc4, 0x13, 0xc4, 0x2, 0xa9, 0x0, 0x0, 0x53, 0x49, 0x50, 0x2f, 0x32, 0x2e, 0x30, 0x20, 0x32, 0x30, 0x30, 0x20, 0x4f, 0x4b, 0xd, 0xa, 0x43, 0x61, 0x6c, 0x6c, 0x2d, 0x49, 0x44, 0x3a, 0x20, 0x42, 0x43, 0x30, 0x39, 0x39, 0x38, 0x38, 0x34, 0x40, 0x36, 0x64, 0x66, 0x63, 0x66, 0x66, 0x65, 0x38, 0xd, 0xa, 0x43, 0x53, 0x65, 0x71, 0x3a, 0x20, 0x32, 0x31, 0x35, 0x38, 0x33, 0x34, 0x34, 0x38, 0x39, 0x20, 0x4f, 0x50, 0x54, 0x49, 0x4f, 0x4e, 0x53, 0xd, 0xa, 0x46, 0x72, 0x6f, 0x6d, 0x3a, 0x20, 0x3c, 0x73, 0x69, 0x70, 0x3a, 0x31, 0x39, 0x32, 0x2e, 0x31, 0x36, 0x38, 0x2e, 0x31, 0x31, 0x31, 0x2e, 0x31, 0x31, 0x31, 0x3a, 0x35, 0x30, 0x36, 0x30, 0x3e, 0x3b, 0x74, 0x61, 0x67, 0x3d, 0x36, 0x64, 0x66, 0x63, 0x66, 0x66, 0x65, 0x38, 0x2b, 0x31, 0x2b, 0x62, 0x30, 0x61, 0x39, 0x30, 0x30, 0x30, 0x33, 0x2b, 0x63, 0x39, 0x65, 0x66, 0x63, 0x32, 0x30, 0x62, 0xd, 0xa, 0x54, 0x6f, 0x3a, 0x20, 0x3c, 0x73, 0x69, 0x70, 0x3a, 0x31, 0x39, 0x32, 0x2e, 0x31, 0x36, 0x38, 0x2e, 0x31, 0x31, 0x31, 0x2e, 0x31, 0x31, 0x31, 0x3a, 0x35, 0x30, 0x36, 0x30, 0x3b, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x3d, 0x75, 0x64, 0x70, 0x3e, 0x3b, 0x74, 0x61, 0x67, 0x3d, 0x31, 0x38, 0x30, 0x34, 0x61, 0x34, 0x37, 0x64, 0x2b, 0x31, 0x2b, 0x65, 0x31, 0x30, 0x35, 0x30, 0x34, 0x37, 0x30, 0x2b, 0x62, 0x31, 0x32, 0x38, 0x61, 0x35, 0x36, 0x39, 0xd, 0xa, 0x56, 0x69, 0x61, 0x3a, 0x20, 0x53, 0x49, 0x50, 0x2f, 0x32, 0x2e, 0x30, 0x2f, 0x55, 0x44, 0x50, 0x20, 0x31, 0x39, 0x32, 0x2e, 0x31, 0x36, 0x38, 0x2e, 0x31, 0x31, 0x31, 0x2e, 0x31, 0x31, 0x31, 0x3a, 0x35, 0x30, 0x36, 0x30, 0x3b, 0x62, 0x72, 0x61, 0x6e, 0x63, 0x68, 0x3d, 0x7a, 0x39, 0x68, 0x47, 0x34, 0x62, 0x4b, 0x2b, 0x32, 0x31, 0x66, 0x31, 0x31, 0x33, 0x65, 0x37, 0x65, 0x33, 0x64, 0x30, 0x34, 0x63, 0x38, 0x34, 0x36, 0x31, 0x34, 0x38, 0x61, 0x39, 0x61, 0x64, 0x37, 0x36, 0x30, 0x37, 0x61, 0x65, 0x66, 0x61, 0x31, 0x2b, 0x36, 0x64, 0x66, 0x63, 0x66, 0x66, 0x65, 0x38, 0x2b, 0x31, 0xd, 0xa, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x3a, 0x20, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0xd, 0xa, 0x43, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x2d, 0x4c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x3a, 0x20, 0x37, 0x38, 0xd, 0xa, 0x43, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x2d, 0x54, 0x79, 0x70, 0x65, 0x3a, 0x20, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x73, 0x64, 0x70, 0xd, 0xa, 0x53, 0x75, 0x70, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x64, 0x3a, 0x20, 0x31, 0x30, 0x30, 0x72, 0x65, 0x6c, 0x2c, 0x20, 0x74, 0x69, 0x6d, 0x65, 0x72, 0xd, 0xa, 0x41, 0x63, 0x63, 0x65, 0x70, 0x74, 0x2d, 0x4c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x3a, 0x20, 0x65, 0x6e, 0xd, 0xa, 0x41, 0x63, 0x63, 0x65, 0x70, 0x74, 0x2d, 0x45, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x3a, 0x20, 0x69, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0xd, 0xa, 0x41, 0x63, 0x63, 0x65, 0x70, 0x74, 0x3a, 0x20, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x73, 0x64, 0x70, 0x2c, 0x20, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x69, 0x73, 0x75, 0x70, 0x2c, 0x20, 0x6d, 0x75, 0x6c, 0x74, 0x69, 0x70, 0x61, 0x72, 0x74, 0x2f, 0x6d, 0x69, 0x78, 0x65, 0x64, 0xd, 0xa, 0x41, 0x6c, 0x6c, 0x6f, 0x77, 0x3a, 0x20, 0x49, 0x4e, 0x56, 0x49, 0x54, 0x45, 0x2c, 0x20, 0x41, 0x43, 0x4b, 0x2c, 0x20, 0x43, 0x41, 0x4e, 0x43, 0x45, 0x4c, 0x2c, 0x20, 0x42, 0x59, 0x45, 0x2c, 0x20, 0x4f, 0x50, 0x54, 0x49, 0x4f, 0x4e, 0x53, 0x2c, 0x20, 0x4e, 0x4f, 0x54, 0x49, 0x46, 0x59, 0x2c, 0x20, 0x50, 0x52, 0x41, 0x43, 0x4b, 0x2c, 0x20, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x2c, 0x20, 0x49, 0x4e, 0x46, 0x4f, 0x2c, 0x20, 0x52, 0x45, 0x46, 0x45, 0x52, 0xd, 0xa, 0xd, 0xa, 0x76, 0x3d, 0x30, 0xd, 0xa, 0x6f, 0x3d, 0x2d, 0x20, 0x30, 0x20, 0x30, 0x20, 0x49, 0x4e, 0x20, 0x49, 0x50, 0x34, 0x20, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0xd, 0xa, 0x73, 0x3d, 0x2d, 0xd, 0xa, 0x63, 0x3d, 0x49, 0x4e, 0x20, 0x49, 0x50, 0x34, 0x20, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0xd, 0xa, 0x74, 0x3d, 0x30, 0x20, 0x30, 0xd, 0xa, 0x6d, 0x3d, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x20, 0x30, 0x20, 0x52, 0x54, 0x50, 0x2f, 0x41, 0x56, 0x50, 0x20, 0x38}
-	hepLen   = []byte{0x00, 0x00}
-	hepLen7  = []byte{0x00, 0x07}
-	hepLen8  = []byte{0x00, 0x08}
-	hepLen10 = []byte{0x00, 0x0a}
-	chunck16 = []byte{0x00, 0x00}
-	chunck32 = []byte{0x00, 0x00, 0x00, 0x00}
 )
 
 func TestDecodeEncodeHEP(t *testing.T) {
@@ -26,10 +21,156 @@ func TestDecodeEncodeHEP(t *testing.T) {
 		t.Error(err)
 	}
 
-	rawPacket := EncodeHEP(hep)
+	rawPacket, err := EncodeHEP(hep)
+	if err != nil {
+		t.Error(err)
+	}
 	assert.Equal(t, hepPacket, rawPacket)
 }
 
+func TestEncodeDecodeHEP(t *testing.T) {
+	in := &HEP{
+		Version:   10,
+		Protocol:  17,
+		SrcIP:     "2001:db8::1",
+		DstIP:     "2001:db8::2",
+		SrcPort:   5060,
+		DstPort:   5080,
+		Tsec:      1546300800,
+		Tmsec:     123456,
+		ProtoType: 100,
+		NodeID:    2001,
+		NodePW:    "myhep",
+		Payload:   "hello",
+		CID:       "abc@127.0.0.1",
+		Vlan:      42,
+		NodeName:  "sbc",
+		SrcMAC:    "00:1b:21:3a:4c:5d",
+		TCPFlag:   24,
+		MOS:       436,
+		Jitter:    12,
+		Tags:      `{"region":"eu"}`,
+		Chunks:    map[string]string{"3:257": "vendor"},
+	}
+	b, err := EncodeHEP(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := DecodeHEP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, in.SrcIP, out.SrcIP)
+	assert.Equal(t, in.DstIP, out.DstIP)
+	assert.Equal(t, in.Tmsec, out.Tmsec)
+	assert.Equal(t, in.NodeID, out.NodeID)
+	assert.Equal(t, in.Payload, out.Payload)
+	assert.Equal(t, in.CID, out.CID)
+	assert.Equal(t, in.Vlan, out.Vlan)
+	assert.Equal(t, in.NodeName, out.NodeName)
+	assert.Equal(t, in.SrcMAC, out.SrcMAC)
+	assert.Equal(t, in.TCPFlag, out.TCPFlag)
+	assert.Equal(t, in.MOS, out.MOS)
+	assert.Equal(t, in.Jitter, out.Jitter)
+	assert.Equal(t, in.Tags, out.Tags)
+	assert.Equal(t, in.Chunks, out.Chunks)
+
+	in.ProtoType = 1032
+	_, err = EncodeHEP(in)
+	assert.Error(t, err)
+	b, err = EncodeJSONHEP(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = DecodeHEP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint32(1032), out.ProtoType)
+}
+
+func TestDecodeLegacyHEP(t *testing.T) {
+	payload := "OPTIONS sip:a SIP/2.0\r\n"
+
+	v2 := []byte{0x02, 0x10, 0x02, 0x11, 0x13, 0xc4, 0x13, 0xd8,
+		0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02,
+		0x00, 0x2e, 0x2b, 0x5c, 0x40, 0xe2, 0x01, 0x00, 0xd1, 0x07, 0x00, 0x00}
+	hep, err := DecodeHEP(append(v2, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "10.0.0.1", hep.SrcIP)
+	assert.Equal(t, "10.0.0.2", hep.DstIP)
+	assert.Equal(t, uint32(5060), hep.SrcPort)
+	assert.Equal(t, uint32(5080), hep.DstPort)
+	assert.Equal(t, uint32(1546333696), hep.Tsec)
+	assert.Equal(t, uint32(123456), hep.Tmsec)
+	assert.Equal(t, uint32(2001), hep.NodeID)
+	assert.Equal(t, uint32(1), hep.ProtoType)
+	assert.Equal(t, payload, hep.Payload)
+
+	v1 := []byte{0x01, 0x28, 0x0a, 0x11, 0x13, 0xc4, 0x13, 0xd8}
+	v1 = append(v1, net.ParseIP("2001:db8::1")...)
+	v1 = append(v1, net.ParseIP("2001:db8::2")...)
+	hep, err = DecodeHEP(append(v1, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "2001:db8::1", hep.SrcIP)
+	assert.Equal(t, "2001:db8::2", hep.DstIP)
+	assert.Equal(t, payload, hep.Payload)
+
+	_, err = DecodeHEP(v1[:30])
+	assert.Error(t, err)
+}
+
+func TestChunkError(t *testing.T) {
+	// Version chunk followed by a SrcPort chunk with a 1 byte body
+	packet := []byte{0x48, 0x45, 0x50, 0x33, 0x00, 0x14,
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x07, 0x02,
+		0x00, 0x00, 0x00, 0x07, 0x00, 0x07, 0x13}
+	_, err := DecodeHEP(packet)
+	cerr, ok := err.(*ChunkError)
+	if assert.True(t, ok, "%v", err) {
+		assert.Equal(t, &ChunkError{Offset: 13, Type: SrcPort, Length: 7, Reason: "should be 2 byte long but is 1"}, cerr)
+		assert.Equal(t, "HEP chunk 0:7 at offset 13 should be 2 byte long but is 1", cerr.Error())
+	}
+
+	packet[18] = 0x09
+	_, err = DecodeHEP(packet)
+	cerr, ok = err.(*ChunkError)
+	if assert.True(t, ok, "%v", err) {
+		assert.Equal(t, 13, cerr.Offset)
+		assert.Equal(t, uint16(9), cerr.Length)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	sip := &HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 5060, DstPort: 5060,
+		ProtoType: 1, Payload: "OPTIONS sip:bob@example.com SIP/2.0\r\nCall-ID: a84b4c76e66710@pc33\r\nContent-Length: 0\r\n\r\n"}
+	noCSeq, err := EncodeHEP(sip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sip.Payload = "OPTIONS sip:bob@example.com SIP/2.0\r\nCSeq: 1 OPTIONS\r\nContent-Length: 0\r\n\r\n"
+	noCallID, err := EncodeHEP(sip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for class, packet := range map[string][]byte{
+		ClassLength:    hepPacket[:100],
+		ClassChunk:     {0x48, 0x45, 0x50, 0x33, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x01},
+		ClassMalformed: []byte("garbage"),
+		ClassNoCSeq:    noCSeq,
+		ClassNoCallID:  noCallID,
+	} {
+		_, err := DecodeHEP(packet)
+		assert.Equal(t, class, ErrorClass(err), "%v", err)
+	}
+	assert.Equal(t, "", ErrorClass(nil))
+}
+
 func BenchmarkDecodeHEPSIP(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		val, _ := DecodeHEP(hepPacket)
@@ -45,104 +186,73 @@ func BenchmarkDecodeHEP(b *testing.B) {
 	}
 }
 
-// EncodeHEP creates the HEP Packet which
-// will be send to wire
-func EncodeHEP(h *HEP) []byte {
-	hepMsg := makeChuncks(h)
-	binary.BigEndian.PutUint16(hepMsg[4:6], uint16(len(hepMsg)))
-	return hepMsg
-}
+func TestPeekCallID(t *testing.T) {
+	h := &HEP{
+		Version:   2,
+		Protocol:  17,
+		SrcIP:     "10.0.0.1",
+		DstIP:     "10.0.0.2",
+		SrcPort:   5060,
+		DstPort:   5060,
+		ProtoType: 1,
+		NodeID:    2001,
+		Payload:   "INVITE sip:bob@example.com SIP/2.0\r\nVia: SIP/2.0/UDP 10.0.0.1\r\ni: a84b4c76e66710@pc33\r\n\r\nCall-ID: body",
+	}
+	b, err := EncodeHEP(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := PeekCallID(b)
+	assert.Equal(t, "a84b4c76e66710@pc33", string(id))
+	assert.True(t, ok)
+
+	h.CID = "rtcp-correlation"
+	h.Payload = "\x80\xc8\x00\x06"
+	h.ProtoType = 5
+	if b, err = EncodeHEP(h); err != nil {
+		t.Fatal(err)
+	}
+	id, _ = PeekCallID(b)
+	assert.Equal(t, "rtcp-correlation", string(id))
+	id, ok = PeekCallID([]byte("garbage"))
+	assert.Nil(t, id)
+	assert.False(t, ok)
+
+	// protobuf and HEPv2 packets are left to the decoder
+	sip := "OPTIONS sip:a SIP/2.0\r\nCall-ID: peek@compressed\r\n\r\n"
+	h.CID, h.Payload, h.ProtoType = "", sip, 1
+	if b, err = h.Marshal(); err != nil {
+		t.Fatal(err)
+	}
+	id, ok = PeekCallID(b)
+	assert.Nil(t, id)
+	assert.False(t, ok)
+
+	v2 := []byte{0x02, 0x10, 0x02, 0x11, 0x13, 0xc4, 0x13, 0xd8,
+		0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02,
+		0x00, 0x2e, 0x2b, 0x5c, 0x40, 0xe2, 0x01, 0x00, 0xd1, 0x07, 0x00, 0x00}
+	id, ok = PeekCallID(append(v2, sip...))
+	assert.Nil(t, id)
+	assert.False(t, ok)
+
+	// compressed payloads are never inflated, only a CID chunk is read
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(sip))
+	gw.Close()
+	chunk := make([]byte, 6, 6+gz.Len())
+	binary.BigEndian.PutUint16(chunk[2:4], CPayload)
+	binary.BigEndian.PutUint16(chunk[4:6], uint16(6+gz.Len()))
+	b = append([]byte("HEP3\x00\x00"), append(chunk, gz.Bytes()...)...)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)))
+	id, ok = PeekCallID(b)
+	assert.Nil(t, id)
+	assert.False(t, ok)
 
-var w bytes.Buffer
-
-// makeChuncks will construct the respective HEP chunck
-func makeChuncks(h *HEP) []byte {
-	w.Reset()
-	w.Write([]byte{0x48, 0x45, 0x50, 0x33})
-	// hepMsg length placeholder. Will be written later
-	w.Write(hepLen)
-
-	// Chunk IP protocol family (0x02=IPv4, 0x0a=IPv6)
-	w.Write([]byte{0x00, 0x00, 0x00, 0x01})
-	w.Write(hepLen7)
-	w.WriteByte(byte(h.Version))
-
-	// Chunk IP protocol ID (0x06=TCP, 0x11=UDP)
-	w.Write([]byte{0x00, 0x00, 0x00, 0x02})
-	w.Write(hepLen7)
-	w.WriteByte(byte(h.Protocol))
-
-	// Chunk IPv4 source address
-	w.Write([]byte{0x00, 0x00, 0x00, 0x03})
-	binary.BigEndian.PutUint16(hepLen, 6+uint16(len(net.ParseIP(h.SrcIP).To4())))
-	w.Write(hepLen)
-	w.Write([]byte(net.ParseIP(h.SrcIP).To4()))
-
-	// Chunk IPv4 destination address
-	w.Write([]byte{0x00, 0x00, 0x00, 0x04})
-	binary.BigEndian.PutUint16(hepLen, 6+uint16(len(net.ParseIP(h.DstIP).To4())))
-	w.Write(hepLen)
-	w.Write([]byte(net.ParseIP(h.DstIP).To4()))
-
-	// Chunk protocol source port
-	w.Write([]byte{0x00, 0x00, 0x00, 0x07})
-	w.Write(hepLen8)
-	binary.BigEndian.PutUint16(chunck16, uint16(h.SrcPort))
-	w.Write(chunck16)
-
-	// Chunk protocol destination port
-	w.Write([]byte{0x00, 0x00, 0x00, 0x08})
-	w.Write(hepLen8)
-	binary.BigEndian.PutUint16(chunck16, uint16(h.DstPort))
-	w.Write(chunck16)
-
-	// Chunk unix timestamp, seconds
-	w.Write([]byte{0x00, 0x00, 0x00, 0x09})
-	w.Write(hepLen10)
-	binary.BigEndian.PutUint32(chunck32, h.Tsec)
-	w.Write(chunck32)
-
-	// Chunk unix timestamp, microseconds offset
-	w.Write([]byte{0x00, 0x00, 0x00, 0x0a})
-	w.Write(hepLen10)
-	binary.BigEndian.PutUint32(chunck32, h.Tmsec)
-	w.Write(chunck32)
-
-	// Chunk protocol type (DNS, LOG, RTCP, SIP)
-	w.Write([]byte{0x00, 0x00, 0x00, 0x0b})
-	w.Write(hepLen7)
-	w.WriteByte(byte(h.ProtoType))
-
-	// Chunk capture agent ID
-	w.Write([]byte{0x00, 0x00, 0x00, 0x0c})
-	w.Write(hepLen10)
-	binary.BigEndian.PutUint32(chunck32, h.NodeID)
-	w.Write(chunck32)
-
-	// Chunk keep alive timer
-	//w.Write([]byte{0x00, 0x00, 0x00, 0x0d})
-
-	// Chunk authenticate key (plain text / TLS connection)
-	w.Write([]byte{0x00, 0x00, 0x00, 0x0e})
-	binary.BigEndian.PutUint16(hepLen, 6+uint16(len(h.NodePW)))
-	w.Write(hepLen)
-	w.Write([]byte(h.NodePW))
-
-	// Chunk captured packet payload
-	w.Write([]byte{0x00, 0x00, 0x00, 0x0f})
-	binary.BigEndian.PutUint16(hepLen, 6+uint16(len(h.Payload)))
-	w.Write(hepLen)
-	w.Write([]byte(h.Payload))
-
-	// Chunk captured compressed payload (gzip/inflate)
-	//w.Write([]byte{0x00,0x00, 0x00,0x10})
-
-	if h.CID != "" && h.ProtoType != 1 {
-		// Chunk internal correlation id
-		w.Write([]byte{0x00, 0x00, 0x00, 0x11})
-		binary.BigEndian.PutUint16(hepLen, 6+uint16(len(h.CID)))
-		w.Write(hepLen)
-		w.Write([]byte(h.CID))
-	}
-	return w.Bytes()
+	cid := []byte("\x00\x00\x00\x11\x00\x0fcid@chunk")
+	b = append(b, cid...)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)))
+	id, ok = PeekCallID(b)
+	assert.Equal(t, "cid@chunk", string(id))
+	assert.True(t, ok)
 }
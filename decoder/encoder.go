@@ -0,0 +1,207 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EncodeHEP returns the HEP3 wire representation of h.
+// It is the counterpart of DecodeHEP.
+func EncodeHEP(h *HEP) ([]byte, error) {
+	return h.MarshalHEP3(nil)
+}
+
+// EncodeJSONHEP encodes a HEP decoded from JSON. Missing Tsec and Tmsec are
+// taken from Timestamp or the current time and a missing Version from SrcIP.
+// Protocol types which don't fit into the one byte HEP3 chunk, like 1032 for
+// Janus, are encoded as protobuf.
+func EncodeJSONHEP(h *HEP) ([]byte, error) {
+	if h.Tsec == 0 && h.Tmsec == 0 {
+		t := h.Timestamp
+		if t.IsZero() {
+			t = time.Now()
+		}
+		h.Tsec, h.Tmsec = uint32(t.Unix()), uint32(t.Nanosecond()/1000)
+	}
+	if h.Version == 0 {
+		h.Version = 2
+		if strings.Contains(h.SrcIP, ":") {
+			h.Version = 10
+		}
+	}
+	if h.ProtoType > 0xff {
+		return h.Marshal()
+	}
+	return EncodeHEP(h)
+}
+
+// MarshalHEP3 appends the HEP3 encoded packet to b. Protocol types above
+// 255 don't fit into the one byte chunk and return an error.
+func (h *HEP) MarshalHEP3(b []byte) ([]byte, error) {
+	if h.ProtoType > 0xff {
+		return nil, fmt.Errorf("can't encode HEP3 protocol type %d, it exceeds one byte", h.ProtoType)
+	}
+	start := len(b)
+	b = append(b, 0x48, 0x45, 0x50, 0x33)
+	// HEP packet length placeholder. Will be written at the end
+	b = append(b, 0x00, 0x00)
+
+	b = appendUint8Chunk(b, Version, h.Version)
+	b = appendUint8Chunk(b, Protocol, h.Protocol)
+
+	srcIP, dstIP := net.ParseIP(h.SrcIP), net.ParseIP(h.DstIP)
+	if srcIP == nil || dstIP == nil {
+		return nil, fmt.Errorf("can't encode HEP with invalid IP %q or %q", h.SrcIP, h.DstIP)
+	}
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		b = appendChunk(b, IP4SrcIP, src4)
+		b = appendChunk(b, IP4DstIP, dst4)
+	} else {
+		b = appendChunk(b, IP6SrcIP, srcIP.To16())
+		b = appendChunk(b, IP6DstIP, dstIP.To16())
+	}
+
+	b = appendUint16Chunk(b, SrcPort, h.SrcPort)
+	b = appendUint16Chunk(b, DstPort, h.DstPort)
+	b = appendUint32Chunk(b, Tsec, h.Tsec)
+	b = appendUint32Chunk(b, Tmsec, h.Tmsec)
+	b = appendUint8Chunk(b, ProtoType, h.ProtoType)
+	b = appendUint32Chunk(b, NodeID, h.NodeID)
+	if h.KeepAlive != 0 {
+		b = appendUint16Chunk(b, KeepAlive, h.KeepAlive)
+	}
+	b = appendChunk(b, NodePW, []byte(h.NodePW))
+
+	if len(h.Payload) > 0xffff-6 {
+		return nil, fmt.Errorf("can't encode HEP payload with length %d", len(h.Payload))
+	}
+	b = appendChunk(b, Payload, []byte(h.Payload))
+
+	// The CID of SIP packets is derived from the Call-ID, so only
+	// send it when it was set by the agent or a script.
+	if h.CID != "" && (h.SIP == nil || (h.CID != h.SIP.CallID && h.CID != h.SIP.XCallID)) {
+		b = appendChunk(b, CID, []byte(h.CID))
+	}
+	if h.Vlan != 0 {
+		b = appendUint16Chunk(b, Vlan, h.Vlan)
+	}
+	// The NodeName defaults to the NodeID.
+	if h.NodeName != "" && h.NodeName != strconv.FormatUint(uint64(h.NodeID), 10) {
+		b = appendChunk(b, NodeName, []byte(h.NodeName))
+	}
+	for _, mac := range []struct {
+		chunkType uint16
+		addr      string
+	}{{SrcMAC, h.SrcMAC}, {DstMAC, h.DstMAC}} {
+		if mac.addr == "" {
+			continue
+		}
+		hw, err := net.ParseMAC(mac.addr)
+		if err != nil {
+			return nil, fmt.Errorf("can't encode HEP with invalid MAC %q", mac.addr)
+		}
+		b = appendChunk(b, mac.chunkType, hw)
+	}
+	if h.EthType != 0 {
+		b = appendUint16Chunk(b, EthType, h.EthType)
+	}
+	if h.TCPFlag != 0 {
+		b = appendUint8Chunk(b, TCPFlag, h.TCPFlag)
+	}
+	if h.TOS != 0 {
+		b = appendUint8Chunk(b, TOS, h.TOS)
+	}
+	if h.MOS != 0 {
+		b = appendUint16Chunk(b, MOS, h.MOS)
+	}
+	if h.RFactor != 0 {
+		b = appendUint16Chunk(b, RFactor, h.RFactor)
+	}
+	if h.GeoLoc != "" {
+		b = appendChunk(b, GeoLoc, []byte(h.GeoLoc))
+	}
+	if h.Jitter != 0 {
+		b = appendUint32Chunk(b, Jitter, h.Jitter)
+	}
+	if h.TransType != "" {
+		b = appendChunk(b, TransType, []byte(h.TransType))
+	}
+	if h.JSONKeys != "" {
+		b = appendChunk(b, JSONKeys, []byte(h.JSONKeys))
+	}
+	if h.Tags != "" {
+		b = appendChunk(b, Tags, []byte(h.Tags))
+	}
+	if h.TagType != 0 {
+		b = appendUint16Chunk(b, TagType, h.TagType)
+	}
+
+	keys := make([]string, 0, len(h.Chunks))
+	for k := range h.Chunks {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		vendorID, chunkType, err := parseChunkKey(k)
+		if err != nil {
+			return nil, err
+		}
+		b = appendChunkHeader(b, vendorID, chunkType, len(h.Chunks[k]))
+		b = append(b, h.Chunks[k]...)
+	}
+
+	length := len(b) - start
+	if length > 0xffff {
+		return nil, fmt.Errorf("HEP packet length %d exceeds 65535 byte", length)
+	}
+	binary.BigEndian.PutUint16(b[start+4:start+6], uint16(length))
+	return b, nil
+}
+
+func parseChunkKey(key string) (uint16, uint16, error) {
+	i := strings.IndexByte(key, ':')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid HEP chunk key %q", key)
+	}
+	vendorID, err := strconv.ParseUint(key[:i], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid HEP chunk key %q", key)
+	}
+	chunkType, err := strconv.ParseUint(key[i+1:], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid HEP chunk key %q", key)
+	}
+	return uint16(vendorID), uint16(chunkType), nil
+}
+
+func appendChunkHeader(b []byte, vendorID, chunkType uint16, bodyLen int) []byte {
+	b = append(b, byte(vendorID>>8), byte(vendorID))
+	b = append(b, byte(chunkType>>8), byte(chunkType))
+	l := uint16(6 + bodyLen)
+	return append(b, byte(l>>8), byte(l))
+}
+
+func appendChunk(b []byte, chunkType uint16, body []byte) []byte {
+	b = appendChunkHeader(b, 0, chunkType, len(body))
+	return append(b, body...)
+}
+
+func appendUint8Chunk(b []byte, chunkType uint16, v uint32) []byte {
+	b = appendChunkHeader(b, 0, chunkType, 1)
+	return append(b, byte(v))
+}
+
+func appendUint16Chunk(b []byte, chunkType uint16, v uint32) []byte {
+	b = appendChunkHeader(b, 0, chunkType, 2)
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32Chunk(b []byte, chunkType uint16, v uint32) []byte {
+	b = appendChunkHeader(b, 0, chunkType, 4)
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,35 @@
+package decoder
+
+// Classes of decoding errors as returned by ErrorClass.
+const (
+	ClassMalformed   = "malformed"
+	ClassLength      = "bad_length"
+	ClassChunk       = "bad_chunk"
+	ClassCompression = "compression"
+	ClassSIP         = "sip_parse"
+	ClassNoCSeq      = "missing_cseq"
+	ClassNoCallID    = "missing_callid"
+)
+
+// decodeError is a decoding error with its class.
+type decodeError struct {
+	class string
+	msg   string
+}
+
+func (e *decodeError) Error() string {
+	return e.msg
+}
+
+// ErrorClass returns the class of an error returned by DecodeHEP.
+func ErrorClass(err error) string {
+	switch e := err.(type) {
+	case nil:
+		return ""
+	case *ChunkError:
+		return ClassChunk
+	case *decodeError:
+		return e.class
+	}
+	return ClassMalformed
+}
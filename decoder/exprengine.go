@@ -38,6 +38,8 @@ func (e *ExprEngine) GetHEPNodeID() uint32 { return e.hepPkt.GetNodeID() }
 
 func (e *ExprEngine) GetHEPCID() string { return e.hepPkt.GetCID() }
 
+func (e *ExprEngine) GetHEPChunk(key string) string { return e.hepPkt.Chunks[key] }
+
 func (e *ExprEngine) GetSIPStruct() *sipparser.SipMsg { return e.hepPkt.SIP }
 
 func (e *ExprEngine) GetSIPCallID() string {
@@ -176,6 +178,7 @@ func NewExprEngine() (*ExprEngine, error) {
 		"GetHEPTimeUseconds": e.GetHEPTimeUseconds,
 		"GetHEPNodeID":       e.GetHEPNodeID,
 		"GetHEPCID":          e.GetHEPCID,
+		"GetHEPChunk":        e.GetHEPChunk,
 		"GetSIPStruct":       e.GetSIPStruct,
 		"GetSIPCallID":       e.GetSIPCallID,
 		"GetRawMessage":      e.GetRawMessage,
@@ -1,48 +1,78 @@
 package decoder
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"net"
 	"strconv"
 )
 
+// ChunkError describes the HEP3 chunk which failed validation.
+type ChunkError struct {
+	Offset   int // offset of the chunk in the packet
+	VendorID uint16
+	Type     uint16
+	Length   uint16 // length of the chunk including its 6 byte header
+	Reason   string
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("HEP chunk %d:%d at offset %d %s", e.VendorID, e.Type, e.Offset, e.Reason)
+}
+
 func (h *HEP) parseHEP(packet []byte) error {
 	length := binary.BigEndian.Uint16(packet[4:6])
 	if int(length) != len(packet) {
-		return fmt.Errorf("HEP packet length is %d but should be %d", len(packet), length)
+		return &decodeError{ClassLength, fmt.Sprintf("HEP packet length is %d but should be %d", len(packet), length)}
 	}
 	currentByte := uint16(6)
 
 	for currentByte < length {
 		hepChunk := packet[currentByte:]
 		if len(hepChunk) < 6 {
-			return fmt.Errorf("HEP chunk must be >= 6 byte long but is %d", len(hepChunk))
+			return &ChunkError{Offset: int(currentByte), Length: uint16(len(hepChunk)),
+				Reason: fmt.Sprintf("must be >= 6 byte long but is %d", len(hepChunk))}
 		}
-		//chunkVendorId := binary.BigEndian.Uint16(hepChunk[:2])
+		chunkVendorID := binary.BigEndian.Uint16(hepChunk[:2])
 		chunkType := binary.BigEndian.Uint16(hepChunk[2:4])
 		chunkLength := binary.BigEndian.Uint16(hepChunk[4:6])
 		if len(hepChunk) < int(chunkLength) || int(chunkLength) < 6 {
-			return fmt.Errorf("HEP chunk with %d byte < chunkLength %d or chunkLength < 6", len(hepChunk), chunkLength)
+			return &ChunkError{Offset: int(currentByte), VendorID: chunkVendorID, Type: chunkType, Length: chunkLength,
+				Reason: fmt.Sprintf("has chunkLength %d but %d byte are left or chunkLength < 6", chunkLength, len(hepChunk))}
 		}
 		chunkBody := hepChunk[6:chunkLength]
 
+		if chunkVendorID != 0 {
+			h.setChunk(chunkVendorID, chunkType, chunkBody)
+			currentByte += chunkLength
+			continue
+		}
+
+		sizeErr := func(want string) error {
+			return &ChunkError{Offset: int(currentByte), Type: chunkType, Length: chunkLength,
+				Reason: fmt.Sprintf("should be %s byte long but is %d", want, len(chunkBody))}
+		}
 		switch chunkType {
-		case Version, Protocol, ProtoType:
+		case Version, Protocol, ProtoType, TCPFlag, TOS:
 			if len(chunkBody) != 1 {
-				return fmt.Errorf("HEP chunkType %d should be 1 byte long but is %d", chunkType, len(chunkBody))
+				return sizeErr("1")
 			}
-		case SrcPort, DstPort, Vlan:
+		case SrcPort, DstPort, Vlan, KeepAlive, EthType, MOS, RFactor, TagType:
 			if len(chunkBody) != 2 {
-				return fmt.Errorf("HEP chunkType %d should be 2 byte long but is %d", chunkType, len(chunkBody))
+				return sizeErr("2")
 			}
-		case IP4SrcIP, IP4DstIP, Tsec, Tmsec, NodeID:
+		case IP4SrcIP, IP4DstIP, Tsec, Tmsec, NodeID, Jitter:
 			if len(chunkBody) != 4 {
-				return fmt.Errorf("HEP chunkType %d should be 4 byte long but is %d", chunkType, len(chunkBody))
+				return sizeErr("4")
 			}
 		case IP6SrcIP, IP6DstIP:
 			if len(chunkBody) != 16 {
-				return fmt.Errorf("HEP chunkType %d should be 16 byte long but is %d", chunkType, len(chunkBody))
+				return sizeErr("16")
+			}
+		case SrcMAC, DstMAC:
+			if len(chunkBody) != 6 && len(chunkBody) != 8 {
+				return sizeErr("6 or 8")
 			}
 		}
 
@@ -93,15 +123,113 @@ func (h *HEP) parseHEP(packet []byte) error {
 			h.NodePW = string(chunkBody)
 		case Payload:
 			h.Payload = string(chunkBody)
+		case CPayload:
+			h.Payload = string(chunkBody)
+			h.compressed = true
 		case CID:
 			h.CID = string(chunkBody)
 		case Vlan:
 			h.Vlan = uint32(binary.BigEndian.Uint16(chunkBody))
 		case NodeName:
 			h.NodeName = string(chunkBody)
+		case KeepAlive:
+			h.KeepAlive = uint32(binary.BigEndian.Uint16(chunkBody))
+		case SrcMAC:
+			h.SrcMAC = net.HardwareAddr(chunkBody[len(chunkBody)-6:]).String()
+		case DstMAC:
+			h.DstMAC = net.HardwareAddr(chunkBody[len(chunkBody)-6:]).String()
+		case EthType:
+			h.EthType = uint32(binary.BigEndian.Uint16(chunkBody))
+		case TCPFlag:
+			h.TCPFlag = uint32(chunkBody[0])
+		case TOS:
+			h.TOS = uint32(chunkBody[0])
+		case MOS:
+			h.MOS = uint32(binary.BigEndian.Uint16(chunkBody))
+		case RFactor:
+			h.RFactor = uint32(binary.BigEndian.Uint16(chunkBody))
+		case GeoLoc:
+			h.GeoLoc = string(chunkBody)
+		case Jitter:
+			h.Jitter = binary.BigEndian.Uint32(chunkBody)
+		case TransType:
+			h.TransType = string(chunkBody)
+		case JSONKeys:
+			h.JSONKeys = string(chunkBody)
+		case Tags:
+			h.Tags = string(chunkBody)
+		case TagType:
+			h.TagType = uint32(binary.BigEndian.Uint16(chunkBody))
 		default:
+			h.setChunk(chunkVendorID, chunkType, chunkBody)
 		}
 		currentByte += chunkLength
 	}
 	return nil
 }
+
+// setChunk keeps vendor specific or unknown chunks
+func (h *HEP) setChunk(vendorID, chunkType uint16, body []byte) {
+	if h.Chunks == nil {
+		h.Chunks = make(map[string]string)
+	}
+	h.Chunks[chunkKey(vendorID, chunkType)] = string(body)
+}
+
+func chunkKey(vendorID, chunkType uint16) string {
+	return strconv.Itoa(int(vendorID)) + ":" + strconv.Itoa(int(chunkType))
+}
+
+// HEP3Magic is the ID which starts every HEP3 packet.
+const HEP3Magic = "HEP3"
+
+// IsHEP3 reports whether the packet starts with the HEP3 ID.
+func IsHEP3(packet []byte) bool {
+	return bytes.HasPrefix(packet, []byte(HEP3Magic))
+}
+
+// IsLegacyHEP reports whether the packet looks like a HEPv1 or HEPv2 packet
+// as sent by the Kamailio and OpenSIPS siptrace modules.
+func IsLegacyHEP(packet []byte) bool {
+	return len(packet) >= 8 && (packet[0] == 1 || packet[0] == 2) &&
+		(packet[2] == 2 || packet[2] == 10)
+}
+
+// parseLegacyHEP decodes HEPv1 and HEPv2 packets. The 8 byte header is
+// followed by the IPv4 or IPv6 addresses, the HEPv2 time header and the SIP payload.
+func (h *HEP) parseLegacyHEP(packet []byte) error {
+	version := packet[0]
+	h.Version = uint32(packet[2])
+	h.Protocol = uint32(packet[3])
+	h.SrcPort = uint32(binary.BigEndian.Uint16(packet[4:6]))
+	h.DstPort = uint32(binary.BigEndian.Uint16(packet[6:8]))
+
+	ipLen := 4
+	if h.Version == 10 {
+		ipLen = 16
+	}
+	// hp_l is set inconsistently by the agents, so derive the offset from the family
+	offset := 8 + 2*ipLen
+	if version == 2 {
+		// tv_sec, tv_usec and captid are sent in host byte order
+		offset += 12
+	}
+	if len(packet) <= offset {
+		return &decodeError{ClassLength, fmt.Sprintf("HEPv%d packet with %d byte is too short", version, len(packet))}
+	}
+
+	h.SrcIP = net.IP(packet[8 : 8+ipLen]).String()
+	h.DstIP = net.IP(packet[8+ipLen : 8+2*ipLen]).String()
+
+	if version == 2 {
+		th := packet[8+2*ipLen:]
+		h.Tsec = binary.LittleEndian.Uint32(th[0:4])
+		h.Tmsec = binary.LittleEndian.Uint32(th[4:8])
+		h.NodeID = uint32(binary.LittleEndian.Uint16(th[8:10]))
+	}
+
+	h.ProtoType = 1
+	h.ProtoString = "sip"
+	h.Payload = string(packet[offset:])
+	return nil
+}
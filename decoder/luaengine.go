@@ -64,6 +64,10 @@ func (d *LuaEngine) GetHEPNodeID() uint32 {
 	return (*d.hepPkt).GetNodeID()
 }
 
+func (d *LuaEngine) GetHEPChunk(key string) string {
+	return (*d.hepPkt).Chunks[key]
+}
+
 func (d *LuaEngine) GetRawMessage() string {
 	return (*d.hepPkt).GetPayload()
 }
@@ -228,6 +232,7 @@ func NewLuaEngine() (*LuaEngine, error) {
 		"GetHEPTimeSeconds":  d.GetHEPTimeSeconds,
 		"GetHEPTimeUseconds": d.GetHEPTimeUseconds,
 		"GetHEPNodeID":       d.GetHEPNodeID,
+		"GetHEPChunk":        d.GetHEPChunk,
 		"GetRawMessage":      d.GetRawMessage,
 		"SetRawMessage":      d.SetRawMessage,
 		"SetCustomSIPHeader": d.SetCustomSIPHeader,
@@ -0,0 +1,80 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// PeekCallID returns the correlation ID of a HEP3 packet without decoding it.
+// It prefers the CID chunk and falls back to the Call-ID header of an
+// uncompressed SIP payload. Payloads are never inflated, so ok is false for
+// compressed payloads without CID chunk and for HEPv1/v2 and protobuf
+// packets, which only the decoder reads.
+func PeekCallID(packet []byte) (id []byte, ok bool) {
+	if !IsHEP3(packet) {
+		return nil, false
+	}
+	if len(packet) < 6 {
+		return nil, true
+	}
+	length := int(binary.BigEndian.Uint16(packet[4:6]))
+	if length > len(packet) {
+		return nil, true
+	}
+
+	var payload []byte
+	var compressed bool
+	for i := 6; i+6 <= length; {
+		chunkVendorID := binary.BigEndian.Uint16(packet[i : i+2])
+		chunkType := binary.BigEndian.Uint16(packet[i+2 : i+4])
+		chunkLength := int(binary.BigEndian.Uint16(packet[i+4 : i+6]))
+		if chunkLength < 6 || i+chunkLength > length {
+			return nil, true
+		}
+		if chunkVendorID == 0 {
+			switch chunkType {
+			case CID:
+				if chunkLength > 6 {
+					return packet[i+6 : i+chunkLength], true
+				}
+			case Payload:
+				payload = packet[i+6 : i+chunkLength]
+			case CPayload:
+				compressed = true
+			}
+		}
+		i += chunkLength
+	}
+	if compressed {
+		return nil, false
+	}
+	return sipCallID(payload), true
+}
+
+// sipCallID returns the value of the Call-ID or compact i header.
+func sipCallID(msg []byte) []byte {
+	for len(msg) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(msg, '\n'); i >= 0 {
+			line, msg = msg[:i], msg[i+1:]
+		} else {
+			line, msg = msg, nil
+		}
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			// end of the headers
+			return nil
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon < 1 {
+			continue
+		}
+		name := bytes.TrimSpace(line[:colon])
+		if bytes.EqualFold(name, []byte("Call-ID")) || bytes.EqualFold(name, []byte("i")) {
+			if v := bytes.TrimSpace(line[colon+1:]); len(v) > 0 {
+				return v
+			}
+		}
+	}
+	return nil
+}
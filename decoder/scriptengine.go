@@ -25,19 +25,19 @@ type ScriptEngine interface {
 
 // NewScriptEngine returns a script interface
 func NewScriptEngine() (ScriptEngine, error) {
-	switch strings.ToLower(config.Setting.ScriptEngine) {
+	switch strings.ToLower(config.Get().ScriptEngine) {
 	case "lua":
 		return NewLuaEngine()
 	case "expr":
 		return NewExprEngine()
 	}
-	return nil, fmt.Errorf("unknown script engine %s", config.Setting.ScriptEngine)
+	return nil, fmt.Errorf("unknown script engine %s", config.Get().ScriptEngine)
 }
 
 func scanCode() ([]string, *bytes.Buffer, error) {
 	var files []string
 	buf := bytes.NewBuffer(nil)
-	path := config.Setting.ScriptFolder
+	path := config.Get().ScriptFolder
 
 	if path != "" {
 		dir, err := ioutil.ReadDir(path)
@@ -1,21 +1,19 @@
 package decoder
 
 import (
-	"errors"
-
 	"github.com/sipcapture/heplify-server/config"
 	"github.com/sipcapture/heplify-server/sipparser"
 )
 
 func (h *HEP) parseSIP() error {
-	h.SIP = sipparser.ParseMsg(h.Payload, config.Setting.AlegIDs, config.Setting.CustomHeader)
+	h.SIP = sipparser.ParseMsg(h.Payload, config.Get().AlegIDs, config.Get().CustomHeader)
 
 	if h.SIP.Error != nil {
-		return h.SIP.Error
+		return &decodeError{ClassSIP, h.SIP.Error.Error()}
 	} else if len(h.SIP.CseqMethod) < 3 {
-		return errors.New("could not find a valid CSeq in packet")
+		return &decodeError{ClassNoCSeq, "could not find a valid CSeq in packet"}
 	} else if len(h.SIP.CallID) < 1 {
-		return errors.New("could not find a valid Call-ID in packet")
+		return &decodeError{ClassNoCallID, "could not find a valid Call-ID in packet"}
 	}
 	if h.SIP.FirstMethod == "" {
 		h.SIP.FirstMethod = h.SIP.FirstResp
@@ -37,7 +35,7 @@ func (h *HEP) parseSIP() error {
 			h.CID = h.SIP.CallID
 		}
 		/* if Asterisk sends the correlation_id already but we wanna force use B-Leg (X-CID)*/
-	} else if config.Setting.ForceALegID && h.SIP.XCallID != "" {
+	} else if config.Get().ForceALegID && h.SIP.XCallID != "" {
 		h.CID = h.SIP.XCallID
 	}
 
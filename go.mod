@@ -3,6 +3,7 @@ module github.com/sipcapture/heplify-server
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/VictoriaMetrics/fastcache v1.5.7
 	github.com/antonmedv/expr v1.8.8
 	github.com/buger/jsonparser v1.0.0
@@ -31,7 +32,8 @@ require (
 	github.com/valyala/fasttemplate v1.1.1
 	golang.org/x/net v0.0.0-20200602114024-627f9648deb9 // indirect
 	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
+	golang.org/x/sys v0.0.0-20200615200032-f1bc736245b1
 	golang.org/x/text v0.3.3 // indirect
 	google.golang.org/genproto v0.0.0-20200619004808-3e7fca5c55db // indirect
-	google.golang.org/grpc v1.29.1
+	google.golang.org/grpc v1.38.0
 )
@@ -0,0 +1,277 @@
+package hepgen
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+// GenConfig describes the synthetic traffic of a Generator.
+type GenConfig struct {
+	Start    time.Time     // time of the first call, defaults to now
+	CPS      float64       // new calls per second
+	RPS      float64       // new registrations per second
+	Limit    int           // number of calls and registrations, 0 is unlimited
+	Hold     time.Duration // time between 200 OK and BYE
+	RTCP     time.Duration // interval of RTCP reports during a call, 0 disables them
+	CallerIP string
+	CalleeIP string
+	NodeID   uint32
+	NodePW   string
+	Seed     int64
+}
+
+// event is a packet scheduled by a running dialog. The packet is built
+// when it is sent to keep the queue small at high call rates.
+type event struct {
+	at    time.Time
+	seq   uint64
+	build func() *decoder.HEP
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Generator is a Source of SIP calls with INVITE, 180, 200, ACK and BYE,
+// REGISTER transactions and RTCP reports in the JSON format of heplify.
+type Generator struct {
+	cfg      GenConfig
+	rnd      *rand.Rand
+	queue    eventQueue
+	seq      uint64
+	started  int
+	nextCall time.Time
+	nextReg  time.Time
+}
+
+// NewGenerator returns a Generator. A config without CPS and RPS generates
+// one call per second.
+func NewGenerator(cfg GenConfig) *Generator {
+	if cfg.Start.IsZero() {
+		cfg.Start = time.Now()
+	}
+	if cfg.CPS <= 0 && cfg.RPS <= 0 {
+		cfg.CPS = 1
+	}
+	if cfg.Hold <= 0 {
+		cfg.Hold = 30 * time.Second
+	}
+	if cfg.CallerIP == "" {
+		cfg.CallerIP = "10.0.0.1"
+	}
+	if cfg.CalleeIP == "" {
+		cfg.CalleeIP = "10.0.0.2"
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = cfg.Start.UnixNano()
+	}
+	return &Generator{
+		cfg:      cfg,
+		rnd:      rand.New(rand.NewSource(cfg.Seed)),
+		nextCall: cfg.Start,
+		nextReg:  cfg.Start,
+	}
+}
+
+// Next returns the next packet in time order.
+func (g *Generator) Next() (*Packet, error) {
+	for {
+		if start, isCall, ok := g.nextDialog(); ok && (len(g.queue) == 0 || !start.After(g.queue[0].at)) {
+			if isCall {
+				g.call(start)
+				g.nextCall = g.nextCall.Add(interval(g.cfg.CPS))
+			} else {
+				g.register(start)
+				g.nextReg = g.nextReg.Add(interval(g.cfg.RPS))
+			}
+			g.started++
+			continue
+		}
+		if len(g.queue) == 0 {
+			return nil, io.EOF
+		}
+
+		e := heap.Pop(&g.queue).(*event)
+		pkt := e.build()
+		pkt.Tsec, pkt.Tmsec = uint32(e.at.Unix()), uint32(e.at.Nanosecond()/1000)
+		pkt.NodeID, pkt.NodePW = g.cfg.NodeID, g.cfg.NodePW
+		b, err := decoder.EncodeHEP(pkt)
+		if err != nil {
+			return nil, err
+		}
+		return &Packet{Time: e.at, Data: b}, nil
+	}
+}
+
+func (g *Generator) nextDialog() (time.Time, bool, bool) {
+	if g.cfg.Limit > 0 && g.started >= g.cfg.Limit {
+		return time.Time{}, false, false
+	}
+	switch {
+	case g.cfg.CPS > 0 && g.cfg.RPS > 0:
+		if g.nextReg.Before(g.nextCall) {
+			return g.nextReg, false, true
+		}
+		return g.nextCall, true, true
+	case g.cfg.CPS > 0:
+		return g.nextCall, true, true
+	}
+	return g.nextReg, false, true
+}
+
+func interval(rate float64) time.Duration {
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// dialog holds the header values of a call or registration.
+type dialog struct {
+	callID   string
+	from     string
+	to       string
+	fromTag  string
+	toTag    string
+	branch   string
+	callerIP string
+	calleeIP string
+}
+
+func (g *Generator) newDialog() *dialog {
+	n := g.started
+	return &dialog{
+		callID:   fmt.Sprintf("%016x@%s", g.rnd.Uint64(), g.cfg.CallerIP),
+		from:     strconv.Itoa(100000 + n%900000),
+		to:       strconv.Itoa(200000 + n%800000),
+		fromTag:  strconv.FormatUint(uint64(g.rnd.Uint32()), 16),
+		toTag:    strconv.FormatUint(uint64(g.rnd.Uint32()), 16),
+		branch:   "z9hG4bK" + strconv.FormatUint(g.rnd.Uint64(), 36),
+		callerIP: g.cfg.CallerIP,
+		calleeIP: g.cfg.CalleeIP,
+	}
+}
+
+func (g *Generator) schedule(at time.Time, build func() *decoder.HEP) {
+	g.seq++
+	heap.Push(&g.queue, &event{at: at, seq: g.seq, build: build})
+}
+
+// sip schedules a SIP message, requests are sent by the caller.
+func (g *Generator) sip(at time.Time, fromCaller bool, msg func() string) {
+	src, dst := g.cfg.CallerIP, g.cfg.CalleeIP
+	if !fromCaller {
+		src, dst = dst, src
+	}
+	g.schedule(at, func() *decoder.HEP {
+		return newHEP(src, dst, 5060, 5060, 1, msg())
+	})
+}
+
+func (g *Generator) call(start time.Time) {
+	d := g.newDialog()
+	answer := start.Add(2 * time.Second)
+	bye := answer.Add(g.cfg.Hold)
+
+	g.sip(start, true, func() string { return d.request("INVITE", 1, false, sdp(d.callerIP)) })
+	g.sip(start.Add(100*time.Millisecond), false, func() string { return d.response("180 Ringing", "INVITE", 1, "") })
+	g.sip(answer, false, func() string { return d.response("200 OK", "INVITE", 1, sdp(d.calleeIP)) })
+	g.sip(answer.Add(50*time.Millisecond), true, func() string { return d.request("ACK", 1, true, "") })
+	if g.cfg.RTCP > 0 {
+		for at := answer.Add(g.cfg.RTCP); at.Before(bye); at = at.Add(g.cfg.RTCP) {
+			g.rtcp(at, d, true)
+			g.rtcp(at.Add(10*time.Millisecond), d, false)
+		}
+	}
+	g.sip(bye, true, func() string { return d.request("BYE", 2, true, "") })
+	g.sip(bye.Add(20*time.Millisecond), false, func() string { return d.response("200 OK", "BYE", 2, "") })
+}
+
+func (g *Generator) register(start time.Time) {
+	d := g.newDialog()
+	d.to = d.from
+	g.sip(start, true, func() string { return d.request("REGISTER", 1, false, "") })
+	g.sip(start.Add(20*time.Millisecond), false, func() string { return d.response("200 OK", "REGISTER", 1, "") })
+}
+
+func (g *Generator) rtcp(at time.Time, d *dialog, fromCaller bool) {
+	src, dst := g.cfg.CallerIP, g.cfg.CalleeIP
+	if !fromCaller {
+		src, dst = dst, src
+	}
+	g.schedule(at, func() *decoder.HEP {
+		payload := fmt.Sprintf(`{"ssrc":%d,"type":201,"report_count":1,"report_blocks":[{"source_ssrc":%d,`+
+			`"fraction_lost":%d,"packets_lost":%d,"highest_seq_no":%d,"ia_jitter":%d,"lsr":0,"dlsr":%d}]}`,
+			g.rnd.Uint32(), g.rnd.Uint32(), g.rnd.Intn(5), g.rnd.Intn(20), g.rnd.Intn(65536), g.rnd.Intn(30), g.rnd.Intn(5000))
+		pkt := newHEP(src, dst, 10001, 10001, 5, payload)
+		pkt.CID = d.callID
+		return pkt
+	})
+}
+
+func newHEP(src, dst string, srcPort, dstPort, protoType uint32, payload string) *decoder.HEP {
+	return &decoder.HEP{
+		Version:   2,
+		Protocol:  17,
+		SrcIP:     src,
+		DstIP:     dst,
+		SrcPort:   srcPort,
+		DstPort:   dstPort,
+		ProtoType: protoType,
+		Payload:   payload,
+	}
+}
+
+func sdp(ip string) string {
+	return fmt.Sprintf("v=0\r\no=- 0 0 IN IP4 %s\r\ns=-\r\nc=IN IP4 %s\r\nt=0 0\r\nm=audio 10000 RTP/AVP 8\r\n", ip, ip)
+}
+
+func (d *dialog) request(method string, cseq int, inDialog bool, body string) string {
+	uri := "sip:" + d.to + "@" + d.calleeIP
+	if method == "REGISTER" {
+		uri = "sip:" + d.calleeIP
+	}
+	return d.message(method+" "+uri+" SIP/2.0", method, cseq, inDialog, body)
+}
+
+func (d *dialog) response(status, method string, cseq int, body string) string {
+	return d.message("SIP/2.0 "+status, method, cseq, true, body)
+}
+
+func (d *dialog) message(firstLine, method string, cseq int, toTag bool, body string) string {
+	to := "<sip:" + d.to + "@" + d.calleeIP + ">"
+	if toTag {
+		to += ";tag=" + d.toTag
+	}
+	contentType := ""
+	if body != "" {
+		contentType = "Content-Type: application/sdp\r\n"
+	}
+	// every transaction has its own branch
+	branch := d.branch + strconv.Itoa(cseq)
+	if method == "ACK" {
+		branch += "a"
+	}
+	return fmt.Sprintf("%s\r\nVia: SIP/2.0/UDP %s:5060;branch=%s\r\nFrom: <sip:%s@%s>;tag=%s\r\nTo: %s\r\n"+
+		"Call-ID: %s\r\nCSeq: %d %s\r\nContact: <sip:%s@%s:5060>\r\nUser-Agent: heplify-server\r\n%s"+
+		"Content-Length: %d\r\n\r\n%s",
+		firstLine, d.callerIP, branch, d.from, d.callerIP, d.fromTag, to,
+		d.callID, cseq, method, d.from, d.callerIP, contentType, len(body), body)
+}
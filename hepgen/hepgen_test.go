@@ -0,0 +1,130 @@
+package hepgen
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(t *testing.T, src Source) []*decoder.HEP {
+	var pkts []*decoder.HEP
+	for {
+		p, err := src.Next()
+		if err == io.EOF {
+			return pkts
+		}
+		if !assert.NoError(t, err) {
+			return pkts
+		}
+		h, err := decoder.DecodeHEP(p.Data)
+		if !assert.NoError(t, err) {
+			return pkts
+		}
+		assert.Equal(t, p.Time.Unix(), int64(h.Tsec))
+		pkts = append(pkts, h)
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	start := time.Unix(1546300800, 0)
+	g := NewGenerator(GenConfig{Start: start, CPS: 2, RPS: 1, Limit: 3, Hold: 10 * time.Second, RTCP: 5 * time.Second, Seed: 1})
+	pkts := collect(t, g)
+
+	var first []string
+	calls := map[string]int{}
+	var rtcp int
+	var last uint64
+	for _, h := range pkts {
+		ts := uint64(h.Tsec)*1e6 + uint64(h.Tmsec)
+		assert.True(t, ts >= last, "packets are in time order")
+		last = ts
+		switch h.ProtoType {
+		case 1:
+			line := h.Payload[:strings.Index(h.Payload, "\r\n")]
+			first = append(first, strings.Fields(line)[0]+" "+strings.Fields(line)[1])
+			calls[h.SIP.CallID]++
+		case 5:
+			rtcp++
+			assert.Contains(t, h.Payload, `"report_blocks"`)
+			assert.Contains(t, calls, h.CID)
+		}
+	}
+
+	// two calls with 6 messages and one registration with 2 messages
+	assert.Len(t, calls, 3)
+	assert.Equal(t, 14, len(first))
+	assert.Equal(t, 4, rtcp)
+	assert.Equal(t, "INVITE sip:200000@10.0.0.2", first[0])
+	assert.Contains(t, first, "REGISTER sip:10.0.0.2")
+	assert.Contains(t, first, "SIP/2.0 180")
+	assert.Contains(t, first, "ACK sip:200000@10.0.0.2")
+	assert.Contains(t, first, "BYE sip:200002@10.0.0.2")
+	assert.Equal(t, uint32(start.Unix()), pkts[0].Tsec)
+}
+
+func TestJSONSource(t *testing.T) {
+	for _, in := range []string{
+		`[{"SrcIP":"10.0.0.1","DstIP":"10.0.0.2","ProtoType":100,"Tsec":1546300800,"Payload":"a"},
+		  {"SrcIP":"::1","DstIP":"::2","ProtoType":100,"Tsec":1546300801,"Payload":"b"}]`,
+		"\n{\"SrcIP\":\"10.0.0.1\",\"DstIP\":\"10.0.0.2\",\"ProtoType\":100,\"Tsec\":1546300800,\"Payload\":\"a\"}\n" +
+			"{\"SrcIP\":\"::1\",\"DstIP\":\"::2\",\"ProtoType\":100,\"Tsec\":1546300801,\"Payload\":\"b\"}\n",
+	} {
+		src, err := NewJSONSource(strings.NewReader(in))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		pkts := collect(t, src)
+		if assert.Len(t, pkts, 2) {
+			assert.Equal(t, "a", pkts[0].Payload)
+			assert.Equal(t, uint32(2), pkts[0].Version)
+			assert.Equal(t, "::2", pkts[1].DstIP)
+			assert.Equal(t, uint32(10), pkts[1].Version)
+		}
+	}
+
+	// protocol types above 255 are sent as protobuf like the HTTP ingest does
+	src, err := NewJSONSource(strings.NewReader(`{"SrcIP":"10.0.0.1","DstIP":"10.0.0.2","ProtoType":1032}`))
+	assert.NoError(t, err)
+	if pkts := collect(t, src); assert.Len(t, pkts, 1) {
+		assert.Equal(t, uint32(1032), pkts[0].ProtoType)
+	}
+}
+
+func TestSender(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("udp", ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 3 registrations over 1 second at speed 10 take 100ms
+	start := time.Unix(1546300800, 0)
+	g := NewGenerator(GenConfig{Start: start, RPS: 2, Limit: 3, Seed: 1})
+	s := &Sender{Conn: conn, Speed: 10, Now: true}
+	begin := time.Now()
+	assert.NoError(t, s.Run(g, nil))
+	assert.True(t, time.Since(begin) >= 100*time.Millisecond)
+	packets, _ := s.Stats()
+	assert.Equal(t, uint64(6), packets)
+
+	buf := make([]byte, 65535)
+	ln.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := ln.ReadFrom(buf)
+	if assert.NoError(t, err) {
+		h, err := decoder.DecodeHEP(buf[:n])
+		if assert.NoError(t, err) {
+			assert.True(t, int64(h.Tsec) >= begin.Unix(), "timestamp is overwritten")
+			assert.Equal(t, uint32(1), h.ProtoType)
+		}
+	}
+}
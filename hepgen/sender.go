@@ -0,0 +1,78 @@
+package hepgen
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Sender writes the packets of a Source to a HEP listener.
+type Sender struct {
+	Conn net.Conn
+	// Speed scales the gaps between the packets, 1 keeps the original
+	// timing and 0 sends as fast as possible.
+	Speed float64
+	// Now overwrites the packet timestamps with the send time.
+	Now bool
+
+	packets uint64
+	bytes   uint64
+}
+
+// Stats returns the number of sent packets and bytes.
+func (s *Sender) Stats() (packets, bytes uint64) {
+	return atomic.LoadUint64(&s.packets), atomic.LoadUint64(&s.bytes)
+}
+
+// Run sends the packets until the source is exhausted or stop is closed.
+func (s *Sender) Run(src Source, stop <-chan struct{}) error {
+	var first time.Time
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		p, err := src.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if s.Speed > 0 {
+			if first.IsZero() {
+				first = p.Time
+			}
+			due := start.Add(time.Duration(float64(p.Time.Sub(first)) / s.Speed))
+			if wait := time.Until(due); wait > 0 {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(wait)
+				select {
+				case <-timer.C:
+				case <-stop:
+					return nil
+				}
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		if s.Now {
+			SetTime(p.Data, time.Now())
+		}
+		if _, err = s.Conn.Write(p.Data); err != nil {
+			return err
+		}
+		atomic.AddUint64(&s.packets, 1)
+		atomic.AddUint64(&s.bytes, uint64(len(p.Data)))
+	}
+}
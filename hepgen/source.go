@@ -0,0 +1,139 @@
+// Package hepgen replays stored HEP traffic and generates synthetic SIP
+// calls to load test a heplify-server listener.
+package hepgen
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/pcap"
+)
+
+// Packet is an encoded HEP packet with the time it was captured.
+type Packet struct {
+	Time time.Time
+	Data []byte
+}
+
+// Source returns the packets to send in time order.
+// Next returns io.EOF when there are no packets left.
+type Source interface {
+	Next() (*Packet, error)
+}
+
+type pcapSource struct {
+	r       *pcap.Reader
+	opts    pcap.Options
+	pending []*Packet
+}
+
+// NewPcapSource returns the HEP packets and SIP messages of a pcap or pcapng file.
+func NewPcapSource(r io.Reader, opts pcap.Options) (Source, error) {
+	pr, err := pcap.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &pcapSource{r: pr, opts: opts}, nil
+}
+
+func (s *pcapSource) Next() (*Packet, error) {
+	for len(s.pending) == 0 {
+		p, err := s.r.Next()
+		if err != nil {
+			return nil, err
+		}
+		pkts, err := pcap.HEP(p, s.opts)
+		if err == pcap.ErrSkip {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, b := range pkts {
+			s.pending = append(s.pending, &Packet{Time: p.Timestamp, Data: b})
+		}
+	}
+	p := s.pending[0]
+	s.pending = s.pending[1:]
+	return p, nil
+}
+
+type jsonSource struct {
+	dec  *json.Decoder
+	item int
+}
+
+// NewJSONSource reads a JSON array or newline delimited JSON objects shaped
+// like decoder.HEP, the same format the HTTP ingest endpoint accepts.
+func NewJSONSource(r io.Reader) (Source, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.Discard(1)
+	}
+
+	dec := json.NewDecoder(br)
+	if b, _ := br.Peek(1); b[0] == '[' {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	return &jsonSource{dec: dec}, nil
+}
+
+func (s *jsonSource) Next() (*Packet, error) {
+	if !s.dec.More() {
+		return nil, io.EOF
+	}
+	s.item++
+	pkt := &decoder.HEP{}
+	if err := s.dec.Decode(pkt); err != nil {
+		return nil, fmt.Errorf("item %d: %v", s.item, err)
+	}
+
+	b, err := decoder.EncodeJSONHEP(pkt)
+	if err != nil {
+		return nil, fmt.Errorf("item %d: %v", s.item, err)
+	}
+	t := time.Unix(int64(pkt.Tsec), int64(pkt.Tmsec)*1000)
+	return &Packet{Time: t, Data: b}, nil
+}
+
+// SetTime overwrites the timestamp chunks of a HEP3 packet in place.
+// It reports whether the packet had both chunks, protobuf packets are kept.
+func SetTime(b []byte, t time.Time) bool {
+	if len(b) < 6 || !decoder.IsHEP3(b) {
+		return false
+	}
+	var found int
+	for off := 6; off+6 <= len(b); {
+		vendor := binary.BigEndian.Uint16(b[off:])
+		typ := binary.BigEndian.Uint16(b[off+2:])
+		l := int(binary.BigEndian.Uint16(b[off+4:]))
+		if l < 6 || off+l > len(b) {
+			break
+		}
+		if vendor == 0 && l == 10 {
+			switch typ {
+			case decoder.Tsec:
+				binary.BigEndian.PutUint32(b[off+6:], uint32(t.Unix()))
+				found++
+			case decoder.Tmsec:
+				binary.BigEndian.PutUint32(b[off+6:], uint32(t.Nanosecond()/1000))
+				found++
+			}
+		}
+		off += l
+	}
+	return found == 2
+}
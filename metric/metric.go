@@ -1,10 +1,7 @@
 package metric
 
 import (
-	"os"
-	"os/signal"
 	"runtime"
-	"syscall"
 
 	"github.com/negbie/logp"
 	"github.com/sipcapture/heplify-server/decoder"
@@ -13,7 +10,6 @@ import (
 type Metric struct {
 	H    MetricHandler
 	Chan chan *decoder.HEP
-	quit chan bool
 }
 
 type MetricHandler interface {
@@ -28,8 +24,7 @@ func New(name string) *Metric {
 	}
 
 	return &Metric{
-		H:    register[name],
-		quit: make(chan bool),
+		H: register[name],
 	}
 }
 
@@ -44,27 +39,15 @@ func (m *Metric) Run() error {
 			m.H.expose(m.Chan)
 		}()
 	}
-
-	s := make(chan os.Signal, 1)
-	signal.Notify(s, syscall.SIGHUP)
-	go func() {
-		for {
-			select {
-			case <-s:
-				m.H.reload()
-			case <-m.quit:
-				m.quit <- true
-				return
-			}
-		}
-	}()
-
 	return nil
 }
 
+// Reload applies the PromTargetIP and PromTargetName of config.Get().
+func (m *Metric) Reload() {
+	m.H.reload()
+}
+
 func (m *Metric) End() {
-	m.quit <- true
-	<-m.quit
 	close(m.Chan)
 	logp.Info("close metric channel")
 }
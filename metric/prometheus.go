@@ -29,8 +29,8 @@ type Prometheus struct {
 
 func (p *Prometheus) setup() (err error) {
 	p.TargetConf = new(sync.RWMutex)
-	p.TargetIP = strings.Split(cutSpace(config.Setting.PromTargetIP), ",")
-	p.TargetName = strings.Split(cutSpace(config.Setting.PromTargetName), ",")
+	p.TargetIP = strings.Split(cutSpace(config.Get().PromTargetIP), ",")
+	p.TargetName = strings.Split(cutSpace(config.Get().PromTargetName), ",")
 	p.cache = fastcache.New(cacheSize)
 
 	if len(p.TargetIP) == len(p.TargetName) && p.TargetIP != nil && p.TargetName != nil {
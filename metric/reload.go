@@ -1,7 +1,6 @@
 package metric
 
 import (
-	"io/ioutil"
 	"strings"
 	"unicode"
 
@@ -19,47 +18,24 @@ func cutSpace(str string) string {
 }
 
 func (p *Prometheus) reload() {
-	var fsTargetIP []string
-	var fsTargetName []string
-
-	fb, err := ioutil.ReadFile(config.Setting.Config)
-	if err != nil {
-		logp.Err("%v", err)
+	targetIP := strings.Split(cutSpace(config.Get().PromTargetIP), ",")
+	targetName := strings.Split(cutSpace(config.Get().PromTargetName), ",")
+	if len(targetIP) != len(targetName) {
+		logp.Err("keep old PromTargetIP and PromTargetName, please give every PromTargetIP a unique IP and PromTargetName a unique name")
 		return
 	}
 
-	fs := cutSpace(string(fb))
-
-	if si := strings.Index(fs, "PromTargetIP=\""); si > -1 {
-		s := si + len("PromTargetIP=\"")
-		e := strings.Index(fs[s:], "\"")
-		if e >= 7 {
-			fsTargetIP = strings.Split(fs[s:s+e], ",")
-		}
-	}
-	if si := strings.Index(fs, "PromTargetName=\""); si > -1 {
-		s := si + len("PromTargetName=\"")
-		e := strings.Index(fs[s:], "\"")
-		if e > 0 {
-			fsTargetName = strings.Split(fs[s:s+e], ",")
-		}
-	}
-
-	if fsTargetIP != nil && fsTargetName != nil && len(fsTargetIP) == len(fsTargetName) {
-		p.TargetConf.Lock()
-		p.TargetIP = fsTargetIP
-		p.TargetName = fsTargetName
-		p.TargetEmpty = false
-		p.TargetMap = make(map[string]string)
+	p.TargetConf.Lock()
+	p.TargetIP = targetIP
+	p.TargetName = targetName
+	p.TargetEmpty = len(targetIP[0]) == 0 || len(targetName[0]) == 0
+	p.TargetMap = make(map[string]string)
+	if !p.TargetEmpty {
 		for i := 0; i < len(p.TargetName); i++ {
 			p.TargetMap[p.TargetIP[i]] = p.TargetName[i]
 		}
-		p.TargetConf.Unlock()
-		logp.Info("successfully reloaded PromTargetIP: %#v", fsTargetIP)
-		logp.Info("successfully reloaded PromTargetName: %#v", fsTargetName)
-	} else {
-		logp.Info("failed to reload PromTargetIP: %#v", fsTargetIP)
-		logp.Info("failed to reload PromTargetName: %#v", fsTargetName)
-		logp.Info("please give every PromTargetIP a unique IP and PromTargetName a unique name")
 	}
+	p.TargetConf.Unlock()
+	logp.Info("successfully reloaded PromTargetIP: %#v", targetIP)
+	logp.Info("successfully reloaded PromTargetName: %#v", targetName)
 }
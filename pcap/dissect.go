@@ -0,0 +1,226 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+// link types, see https://www.tcpdump.org/linktypes.html
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSLL2     = 276
+)
+
+// ErrSkip is returned for packets which hold neither HEP, SIP nor RTCP.
+var ErrSkip = errors.New("no HEP, SIP or RTCP payload")
+
+var sipVersion = []byte("SIP/2.0")
+
+// Options are applied to the synthetic HEP packets built from raw SIP and RTCP.
+type Options struct {
+	NodeID   uint32
+	NodeName string
+}
+
+// HEP returns the HEP packets carried by p. HEP packets are returned as
+// they were captured. SIP messages and RTCP over UDP, converted to the
+// JSON report of heplify, are wrapped into HEP3 with the capture timestamp.
+// It returns ErrSkip for all other packets.
+func HEP(p *Packet, opts Options) ([][]byte, error) {
+	l3, ok := linkPayload(p.LinkType, p.Data)
+	if !ok {
+		return nil, ErrSkip
+	}
+	pkt, l4, ok := ipPayload(l3)
+	if !ok {
+		return nil, ErrSkip
+	}
+	payload, ok := transportPayload(pkt, l4)
+	if !ok || len(payload) == 0 {
+		return nil, ErrSkip
+	}
+
+	switch {
+	case decoder.IsHEP3(payload):
+		return splitHEP3(payload), nil
+	case decoder.IsLegacyHEP(payload):
+		return [][]byte{payload}, nil
+	case isSIP(payload):
+		pkt.ProtoType = 1
+	case pkt.Protocol == 17 && isRTCP(payload):
+		pkt.ProtoType = 5
+		payload = rtcpJSON(payload)
+	default:
+		return nil, ErrSkip
+	}
+
+	pkt.Tsec = uint32(p.Timestamp.Unix())
+	pkt.Tmsec = uint32(p.Timestamp.Nanosecond() / 1000)
+	pkt.NodeID = opts.NodeID
+	pkt.NodeName = opts.NodeName
+	pkt.Payload = string(payload)
+	b, err := decoder.EncodeHEP(pkt)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{b}, nil
+}
+
+// linkPayload strips the link layer header and returns the IP packet.
+func linkPayload(linkType uint32, b []byte) ([]byte, bool) {
+	switch linkType {
+	case linkNull, linkLoop:
+		// 4 byte address family in host byte order
+		if len(b) < 4 {
+			return nil, false
+		}
+		return b[4:], true
+	case linkEthernet:
+		if len(b) < 14 {
+			return nil, false
+		}
+		etherType, b := binary.BigEndian.Uint16(b[12:14]), b[14:]
+		for etherType == 0x8100 || etherType == 0x88a8 {
+			if len(b) < 4 {
+				return nil, false
+			}
+			etherType, b = binary.BigEndian.Uint16(b[2:4]), b[4:]
+		}
+		return b, etherType == 0x0800 || etherType == 0x86dd
+	case linkSLL:
+		if len(b) < 16 {
+			return nil, false
+		}
+		return b[16:], true
+	case linkSLL2:
+		if len(b) < 20 {
+			return nil, false
+		}
+		return b[20:], true
+	case linkRaw, linkIPv4, linkIPv6:
+		return b, true
+	}
+	return nil, false
+}
+
+// ipPayload returns a HEP with the addresses of the IP header and the
+// transport layer segment. Fragments are skipped.
+func ipPayload(b []byte) (*decoder.HEP, []byte, bool) {
+	if len(b) < 1 {
+		return nil, nil, false
+	}
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return nil, nil, false
+		}
+		hl := int(b[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(b[2:4]))
+		if hl < 20 || total < hl || total > len(b) {
+			return nil, nil, false
+		}
+		// more fragments flag or fragment offset
+		if binary.BigEndian.Uint16(b[6:8])&0x3fff != 0 {
+			return nil, nil, false
+		}
+		return &decoder.HEP{
+			Version:  2,
+			Protocol: uint32(b[9]),
+			SrcIP:    net.IP(b[12:16]).String(),
+			DstIP:    net.IP(b[16:20]).String(),
+		}, b[hl:total], true
+	case 6:
+		if len(b) < 40 {
+			return nil, nil, false
+		}
+		end := 40 + int(binary.BigEndian.Uint16(b[4:6]))
+		if end > len(b) {
+			return nil, nil, false
+		}
+		pkt := &decoder.HEP{
+			Version: 10,
+			SrcIP:   net.IP(b[8:24]).String(),
+			DstIP:   net.IP(b[24:40]).String(),
+		}
+		next, off := b[6], 40
+		// hop-by-hop, routing and destination options extension headers
+		for next == 0 || next == 43 || next == 60 {
+			if off+8 > end {
+				return nil, nil, false
+			}
+			next, off = b[off], off+8+int(b[off+1])*8
+		}
+		if off > end {
+			return nil, nil, false
+		}
+		pkt.Protocol = uint32(next)
+		return pkt, b[off:end], true
+	}
+	return nil, nil, false
+}
+
+// transportPayload sets the ports of pkt and returns the UDP or TCP payload.
+func transportPayload(pkt *decoder.HEP, b []byte) ([]byte, bool) {
+	switch pkt.Protocol {
+	case 17:
+		if len(b) < 8 {
+			return nil, false
+		}
+		l := int(binary.BigEndian.Uint16(b[4:6]))
+		if l < 8 || l > len(b) {
+			l = len(b)
+		}
+		pkt.SrcPort = uint32(binary.BigEndian.Uint16(b[0:2]))
+		pkt.DstPort = uint32(binary.BigEndian.Uint16(b[2:4]))
+		return b[8:l], true
+	case 6:
+		if len(b) < 20 {
+			return nil, false
+		}
+		off := int(b[12]>>4) * 4
+		if off < 20 || off > len(b) {
+			return nil, false
+		}
+		pkt.SrcPort = uint32(binary.BigEndian.Uint16(b[0:2]))
+		pkt.DstPort = uint32(binary.BigEndian.Uint16(b[2:4]))
+		return b[off:], true
+	}
+	return nil, false
+}
+
+// splitHEP3 splits a segment which can hold several HEP3 packets. A
+// truncated packet at the end is passed on and rejected by the decoder.
+func splitHEP3(b []byte) [][]byte {
+	var pkts [][]byte
+	for len(b) >= 6 && decoder.IsHEP3(b) {
+		l := int(binary.BigEndian.Uint16(b[4:6]))
+		if l < 6 || l > len(b) {
+			l = len(b)
+		}
+		pkts = append(pkts, b[:l])
+		b = b[l:]
+	}
+	return pkts
+}
+
+// isSIP checks for a status line or a request line ending with the SIP version.
+func isSIP(b []byte) bool {
+	if bytes.HasPrefix(b, sipVersion) {
+		return true
+	}
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return false
+	}
+	return bytes.HasSuffix(bytes.TrimRight(b[:i], "\r"), sipVersion)
+}
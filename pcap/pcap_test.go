@@ -0,0 +1,161 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+const sipOptions = "OPTIONS sip:bob@192.168.1.2 SIP/2.0\r\nCall-ID: abc@192.168.1.1\r\nCSeq: 1 OPTIONS\r\n\r\n"
+
+// udpFrame builds an Ethernet frame with a VLAN tag and an IPv4 UDP packet.
+func udpFrame(payload []byte) []byte {
+	b := []byte{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 0x81, 0x00, 0x00, 0x2a, 0x08, 0x00,
+		0x45, 0, 0, 0, 0, 1, 0x40, 0, 64, 17, 0, 0, 192, 168, 1, 1, 192, 168, 1, 2,
+		0x13, 0xc4, 0x13, 0xc5, 0, 0, 0, 0,
+	}
+	binary.BigEndian.PutUint16(b[20:22], uint16(28+len(payload)))
+	binary.BigEndian.PutUint16(b[42:44], uint16(8+len(payload)))
+	return append(b, payload...)
+}
+
+func pcapFile(ts time.Time, frames ...[]byte) []byte {
+	var b bytes.Buffer
+	hdr := []uint32{magicNano, 0x00040002, 0, 0, 65535, linkEthernet}
+	binary.Write(&b, binary.LittleEndian, hdr)
+	for _, f := range frames {
+		binary.Write(&b, binary.LittleEndian, []uint32{uint32(ts.Unix()), uint32(ts.Nanosecond()), uint32(len(f)), uint32(len(f))})
+		b.Write(f)
+	}
+	return b.Bytes()
+}
+
+func pcapngFile(ts time.Time, frame []byte) []byte {
+	var b bytes.Buffer
+	w := func(v ...interface{}) {
+		for _, x := range v {
+			binary.Write(&b, binary.BigEndian, x)
+		}
+	}
+	w(uint32(magicNG), uint32(28), uint32(byteOrderNG), uint16(1), uint16(0), int64(-1), uint32(28))
+	// interface with if_tsresol 10^-9
+	w(uint32(blockIDB), uint32(32), uint16(linkEthernet), uint16(0), uint32(0),
+		uint16(9), uint16(1), []byte{9, 0, 0, 0}, uint16(0), uint16(0), uint32(32))
+	pad := (4 - len(frame)%4) % 4
+	l := uint32(32 + len(frame) + pad)
+	nano := uint64(ts.UnixNano())
+	w(uint32(blockEPB), l, uint32(0), uint32(nano>>32), uint32(nano), uint32(len(frame)), uint32(len(frame)),
+		frame, make([]byte, pad), l)
+	return b.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	ts := time.Unix(1546300800, 123456789)
+	frame := udpFrame([]byte(sipOptions))
+
+	for name, file := range map[string][]byte{
+		"pcap":   pcapFile(ts, frame, frame),
+		"pcapng": pcapngFile(ts, frame),
+	} {
+		r, err := NewReader(bytes.NewReader(file))
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		p, err := r.Next()
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, ts.UnixNano(), p.Timestamp.UnixNano(), name)
+			assert.Equal(t, uint32(linkEthernet), p.LinkType, name)
+			assert.Equal(t, frame, p.Data, name)
+		}
+		if name == "pcap" {
+			_, err = r.Next()
+			assert.NoError(t, err, name)
+		}
+		_, err = r.Next()
+		assert.Equal(t, io.EOF, err, name)
+	}
+
+	// resolutions of 10^-19 or 2^-63 and finer overflow the timestamp units
+	for _, res := range []byte{19, 64, 0x80 | 63} {
+		file := pcapngFile(ts, frame)
+		file[48] = res
+		r, err := NewReader(bytes.NewReader(file))
+		if assert.NoError(t, err) {
+			_, err = r.Next()
+			assert.Error(t, err, "if_tsresol %#x", res)
+		}
+	}
+
+	_, err := NewReader(bytes.NewReader([]byte("not a capture file")))
+	assert.Error(t, err)
+	r, err := NewReader(bytes.NewReader(pcapFile(ts, frame)[:60]))
+	assert.NoError(t, err)
+	_, err = r.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestHEP(t *testing.T) {
+	ts := time.Unix(1546300800, 123456789)
+	opts := Options{NodeID: 7, NodeName: "import"}
+
+	pkts, err := HEP(&Packet{Timestamp: ts, LinkType: linkEthernet, Data: udpFrame([]byte(sipOptions))}, opts)
+	if assert.NoError(t, err) && assert.Len(t, pkts, 1) {
+		h, err := decoder.DecodeHEP(pkts[0])
+		if assert.NoError(t, err) {
+			assert.Equal(t, "192.168.1.1", h.SrcIP)
+			assert.Equal(t, "192.168.1.2", h.DstIP)
+			assert.Equal(t, uint32(5060), h.SrcPort)
+			assert.Equal(t, uint32(5061), h.DstPort)
+			assert.Equal(t, uint32(17), h.Protocol)
+			assert.Equal(t, uint32(1), h.ProtoType)
+			assert.Equal(t, uint32(ts.Unix()), h.Tsec)
+			assert.Equal(t, uint32(123456), h.Tmsec)
+			assert.Equal(t, uint32(7), h.NodeID)
+			assert.Equal(t, sipOptions, h.Payload)
+		}
+	}
+
+	// HEP packets are passed on as captured
+	inner, err := decoder.EncodeHEP(&decoder.HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2",
+		SrcPort: 5060, DstPort: 5060, ProtoType: 100, Payload: "log line"})
+	assert.NoError(t, err)
+	pkts, err = HEP(&Packet{LinkType: linkEthernet, Data: udpFrame(append(append([]byte{}, inner...), inner...))}, opts)
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]byte{inner, inner}, pkts)
+	}
+
+	// RTCP is converted to the JSON report of heplify
+	sr := []byte{
+		0x81, 0xc8, 0, 12, 0, 0, 0, 1, 0xe0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 50, 0, 0, 0x1f, 0x40,
+		0, 0, 0, 2, 0x19, 0, 0, 3, 0, 0, 0x10, 0, 0, 0, 0, 40, 0, 0, 0, 4, 0, 0, 0, 5,
+		0x81, 0xca, 0, 2, 0, 0, 0, 1, 0, 0, 0, 0,
+	}
+	pkts, err = HEP(&Packet{Timestamp: ts, LinkType: linkEthernet, Data: udpFrame(sr)}, opts)
+	if assert.NoError(t, err) && assert.Len(t, pkts, 1) {
+		h, err := decoder.DecodeHEP(pkts[0])
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint32(5), h.ProtoType)
+			assert.Equal(t, uint32(ts.Unix()), h.Tsec)
+			assert.JSONEq(t, `{"sender_information":{"ntp_timestamp_sec":3758096384,"ntp_timestamp_usec":1,
+				"rtp_timestamp":2,"packets":50,"octets":8000},"ssrc":1,"type":200,"report_count":1,
+				"report_blocks":[{"source_ssrc":2,"fraction_lost":25,"packets_lost":3,"highest_seq_no":4096,
+				"ia_jitter":40,"lsr":4,"dlsr":5}],"sdes_ssrc":1}`, h.Payload)
+		}
+	}
+
+	for _, data := range [][]byte{
+		udpFrame([]byte{0x80, 0xc8, 0, 6, 1, 2, 3, 4}),
+		udpFrame([]byte{0x80, 0x48, 0, 1, 1, 2, 3, 4}),
+		udpFrame(nil),
+		udpFrame([]byte(sipOptions))[:30],
+	} {
+		_, err = HEP(&Packet{LinkType: linkEthernet, Data: data}, opts)
+		assert.Equal(t, ErrSkip, err)
+	}
+}
@@ -0,0 +1,279 @@
+// Package pcap reads pcap and pcapng capture files and extracts HEP
+// packets or SIP messages from them.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	magicMicro  = 0xa1b2c3d4
+	magicNano   = 0xa1b23c4d
+	magicNG     = 0x0a0d0d0a
+	byteOrderNG = 0x1a2b3c4d
+
+	// blocks bigger than this are treated as a corrupt file
+	maxBlockLen = 16 << 20
+)
+
+// pcapng block types
+const (
+	blockIDB = 0x00000001
+	blockPB  = 0x00000002
+	blockSPB = 0x00000003
+	blockEPB = 0x00000006
+)
+
+// Packet is a captured frame.
+type Packet struct {
+	Timestamp time.Time
+	LinkType  uint32
+	Data      []byte
+}
+
+type iface struct {
+	linkType uint32
+	snapLen  uint32
+	tsUnits  uint64 // timestamp units per second
+}
+
+// Reader reads the packets of a pcap or pcapng file.
+type Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	ng    bool
+
+	// classic pcap
+	linkType uint32
+	nano     bool
+
+	// pcapng
+	ifaces []iface
+}
+
+// NewReader detects the file format and reads the file header.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: bufio.NewReaderSize(r, 1<<16)}
+	hdr, err := pr.r.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("can't read pcap header: %v", err)
+	}
+
+	switch magic := binary.BigEndian.Uint32(hdr); magic {
+	case magicNG:
+		pr.ng = true
+		if err = pr.readSHB(); err != nil {
+			return nil, err
+		}
+		return pr, nil
+	case magicMicro, magicNano:
+		pr.order = binary.BigEndian
+		pr.nano = magic == magicNano
+	default:
+		switch binary.LittleEndian.Uint32(hdr) {
+		case magicMicro, magicNano:
+			pr.order = binary.LittleEndian
+			pr.nano = binary.LittleEndian.Uint32(hdr) == magicNano
+		default:
+			return nil, fmt.Errorf("unknown capture file magic %x", hdr)
+		}
+	}
+
+	var fh [24]byte
+	if _, err = io.ReadFull(pr.r, fh[:]); err != nil {
+		return nil, fmt.Errorf("can't read pcap header: %v", err)
+	}
+	pr.linkType = pr.order.Uint32(fh[20:24]) & 0x0fffffff
+	return pr, nil
+}
+
+// Next returns the next packet. It returns io.EOF at the end of the file.
+func (r *Reader) Next() (*Packet, error) {
+	if r.ng {
+		return r.nextNG()
+	}
+
+	var rh [16]byte
+	if _, err := io.ReadFull(r.r, rh[:]); err != nil {
+		return nil, err
+	}
+	sec, frac := r.order.Uint32(rh[0:4]), r.order.Uint32(rh[4:8])
+	capLen := r.order.Uint32(rh[8:12])
+	if capLen > maxBlockLen {
+		return nil, fmt.Errorf("invalid pcap record length %d", capLen)
+	}
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	nsec := int64(frac) * 1000
+	if r.nano {
+		nsec = int64(frac)
+	}
+	return &Packet{Timestamp: time.Unix(int64(sec), nsec), LinkType: r.linkType, Data: data}, nil
+}
+
+func (r *Reader) readSHB() error {
+	var h [12]byte
+	if _, err := io.ReadFull(r.r, h[:]); err != nil {
+		return fmt.Errorf("can't read pcapng section header: %v", err)
+	}
+	switch {
+	case binary.BigEndian.Uint32(h[8:12]) == byteOrderNG:
+		r.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(h[8:12]) == byteOrderNG:
+		r.order = binary.LittleEndian
+	default:
+		return fmt.Errorf("invalid pcapng byte order magic %x", h[8:12])
+	}
+	// interface ids are local to a section
+	r.ifaces = r.ifaces[:0]
+
+	blockLen := r.order.Uint32(h[4:8])
+	if blockLen < 28 || blockLen > maxBlockLen || blockLen%4 != 0 {
+		return fmt.Errorf("invalid pcapng section header length %d", blockLen)
+	}
+	_, err := r.r.Discard(int(blockLen) - len(h))
+	return unexpectedEOF(err)
+}
+
+func (r *Reader) nextNG() (*Packet, error) {
+	for {
+		h, err := r.r.Peek(8)
+		if err != nil {
+			if err == io.EOF && len(h) == 0 {
+				return nil, io.EOF
+			}
+			return nil, unexpectedEOF(err)
+		}
+		if binary.BigEndian.Uint32(h[0:4]) == magicNG {
+			if err = r.readSHB(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		blockType, blockLen := r.order.Uint32(h[0:4]), r.order.Uint32(h[4:8])
+		if blockLen < 12 || blockLen > maxBlockLen || blockLen%4 != 0 {
+			return nil, fmt.Errorf("invalid pcapng block length %d", blockLen)
+		}
+		block := make([]byte, blockLen)
+		if _, err = io.ReadFull(r.r, block); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		body := block[8 : blockLen-4]
+
+		switch blockType {
+		case blockIDB:
+			if err = r.readIDB(body); err != nil {
+				return nil, err
+			}
+		case blockEPB:
+			return r.readEPB(body)
+		case blockPB:
+			// obsolete packet block with a 16 bit interface id and drop counter
+			if len(body) < 20 {
+				return nil, errors.New("short pcapng packet block")
+			}
+			epb := make([]byte, len(body))
+			copy(epb, body)
+			r.order.PutUint32(epb[0:4], uint32(r.order.Uint16(body[0:2])))
+			return r.readEPB(epb)
+		case blockSPB:
+			if len(r.ifaces) == 0 {
+				return nil, errors.New("pcapng simple packet block without interface")
+			}
+			if len(body) < 4 {
+				return nil, errors.New("short pcapng simple packet block")
+			}
+			ifc := r.ifaces[0]
+			capLen := r.order.Uint32(body[0:4])
+			if ifc.snapLen > 0 && capLen > ifc.snapLen {
+				capLen = ifc.snapLen
+			}
+			if int(capLen) > len(body)-4 {
+				capLen = uint32(len(body) - 4)
+			}
+			return &Packet{LinkType: ifc.linkType, Data: body[4 : 4+capLen]}, nil
+		}
+	}
+}
+
+func (r *Reader) readIDB(body []byte) error {
+	if len(body) < 8 {
+		return errors.New("short pcapng interface block")
+	}
+	ifc := iface{
+		linkType: uint32(r.order.Uint16(body[0:2])),
+		snapLen:  r.order.Uint32(body[4:8]),
+		tsUnits:  1e6,
+	}
+
+	opts := body[8:]
+	for len(opts) >= 4 {
+		code, l := r.order.Uint16(opts[0:2]), int(r.order.Uint16(opts[2:4]))
+		if code == 0 || 4+l > len(opts) {
+			break
+		}
+		// if_tsresol is a negative power of 10, or of 2 with the high bit set
+		if code == 9 && l == 1 {
+			res := opts[4]
+			base, maxExp := uint64(10), 18
+			if res&0x80 != 0 {
+				base, maxExp = 2, 62
+			}
+			if int(res&0x7f) > maxExp {
+				return fmt.Errorf("unsupported pcapng timestamp resolution %#x", res)
+			}
+			ifc.tsUnits = 1
+			for i := 0; i < int(res&0x7f); i++ {
+				ifc.tsUnits *= base
+			}
+		}
+		opts = opts[4+(l+3)&^3:]
+	}
+	r.ifaces = append(r.ifaces, ifc)
+	return nil
+}
+
+func (r *Reader) readEPB(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, errors.New("short pcapng packet block")
+	}
+	id := r.order.Uint32(body[0:4])
+	if int(id) >= len(r.ifaces) {
+		return nil, fmt.Errorf("pcapng packet block with unknown interface %d", id)
+	}
+	ifc := r.ifaces[id]
+	ts := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+	capLen := r.order.Uint32(body[12:16])
+	if int(capLen) > len(body)-20 {
+		return nil, fmt.Errorf("invalid pcapng packet length %d", capLen)
+	}
+
+	sec, frac := ts/ifc.tsUnits, ts%ifc.tsUnits
+	var nsec int64
+	if ifc.tsUnits > 1e9 {
+		nsec = int64(frac / (ifc.tsUnits / 1e9))
+	} else {
+		nsec = int64(frac * 1e9 / ifc.tsUnits)
+	}
+	return &Packet{
+		Timestamp: time.Unix(int64(sec), nsec),
+		LinkType:  ifc.linkType,
+		Data:      body[20 : 20+capLen],
+	}, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
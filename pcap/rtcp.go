@@ -0,0 +1,106 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+)
+
+// rtcpReport is the JSON representation of RTCP which heplify sends as HEP
+// protocol type 5 and which the metrics and the database outputs expect.
+type rtcpReport struct {
+	SenderInformation rtcpSender  `json:"sender_information"`
+	SSRC              uint32      `json:"ssrc"`
+	Type              uint8       `json:"type"`
+	ReportCount       uint8       `json:"report_count"`
+	ReportBlocks      []rtcpBlock `json:"report_blocks"`
+	SdesSSRC          uint32      `json:"sdes_ssrc"`
+}
+
+type rtcpSender struct {
+	NTPSec      uint32 `json:"ntp_timestamp_sec"`
+	NTPFraction uint32 `json:"ntp_timestamp_usec"`
+	RTPTime     uint32 `json:"rtp_timestamp"`
+	Packets     uint32 `json:"packets"`
+	Octets      uint32 `json:"octets"`
+}
+
+type rtcpBlock struct {
+	SourceSSRC   uint32 `json:"source_ssrc"`
+	FractionLost uint8  `json:"fraction_lost"`
+	PacketsLost  uint32 `json:"packets_lost"`
+	HighestSeq   uint32 `json:"highest_seq_no"`
+	Jitter       uint32 `json:"ia_jitter"`
+	LSR          uint32 `json:"lsr"`
+	DLSR         uint32 `json:"dlsr"`
+}
+
+// isRTCP checks for RTP version 2 and the SR, RR, SDES, BYE or APP packet
+// type of the first packet of a compound RTCP packet.
+func isRTCP(b []byte) bool {
+	if len(b) < 8 || b[0]>>6 != 2 || b[1] < 200 || b[1] > 204 {
+		return false
+	}
+	return (int(binary.BigEndian.Uint16(b[2:4]))+1)*4 <= len(b)
+}
+
+// rtcpJSON converts a compound RTCP packet into the JSON report of heplify.
+// The sender and the report blocks are taken from the first SR or RR.
+func rtcpJSON(b []byte) []byte {
+	r := rtcpReport{ReportBlocks: []rtcpBlock{}}
+	var report bool
+	for len(b) >= 8 {
+		l := (int(binary.BigEndian.Uint16(b[2:4])) + 1) * 4
+		if l > len(b) {
+			break
+		}
+		p := b[:l]
+		b = b[l:]
+		if len(p) < 8 {
+			continue
+		}
+
+		pt := p[1]
+		if r.Type == 0 {
+			r.Type = pt
+			r.SSRC = binary.BigEndian.Uint32(p[4:8])
+		}
+		switch pt {
+		case 200, 201:
+			if report {
+				continue
+			}
+			report = true
+			r.Type, r.SSRC, r.ReportCount = pt, binary.BigEndian.Uint32(p[4:8]), p[0]&0x1f
+			blocks := p[8:]
+			if pt == 200 {
+				if len(p) < 28 {
+					continue
+				}
+				r.SenderInformation = rtcpSender{
+					NTPSec:      binary.BigEndian.Uint32(p[8:12]),
+					NTPFraction: binary.BigEndian.Uint32(p[12:16]),
+					RTPTime:     binary.BigEndian.Uint32(p[16:20]),
+					Packets:     binary.BigEndian.Uint32(p[20:24]),
+					Octets:      binary.BigEndian.Uint32(p[24:28]),
+				}
+				blocks = p[28:]
+			}
+			for i := 0; i < int(r.ReportCount) && len(blocks) >= 24; i++ {
+				r.ReportBlocks = append(r.ReportBlocks, rtcpBlock{
+					SourceSSRC:   binary.BigEndian.Uint32(blocks[0:4]),
+					FractionLost: blocks[4],
+					PacketsLost:  binary.BigEndian.Uint32(blocks[4:8]) & 0xffffff,
+					HighestSeq:   binary.BigEndian.Uint32(blocks[8:12]),
+					Jitter:       binary.BigEndian.Uint32(blocks[12:16]),
+					LSR:          binary.BigEndian.Uint32(blocks[16:20]),
+					DLSR:         binary.BigEndian.Uint32(blocks[20:24]),
+				})
+				blocks = blocks[24:]
+			}
+		case 202:
+			r.SdesSSRC = binary.BigEndian.Uint32(p[4:8])
+		}
+	}
+	j, _ := json.Marshal(r)
+	return j
+}
@@ -20,18 +20,19 @@ type Elasticsearch struct {
 }
 
 func (e *Elasticsearch) setup() error {
+	cfg := config.Get()
 	var err error
 	e.ctx = context.Background()
-	if len(config.Setting.ESUser) > 0 {
+	if len(cfg.ESUser) > 0 {
 		e.client, err = elastic.NewClient(
-			elastic.SetURL(config.Setting.ESAddr),
-			elastic.SetSniff(config.Setting.ESDiscovery),
-			elastic.SetBasicAuth(config.Setting.ESUser, config.Setting.ESPass),
+			elastic.SetURL(cfg.ESAddr),
+			elastic.SetSniff(cfg.ESDiscovery),
+			elastic.SetBasicAuth(cfg.ESUser, cfg.ESPass),
 		)
 	} else {
 		e.client, err = elastic.NewClient(
-			elastic.SetURL(config.Setting.ESAddr),
-			elastic.SetSniff(config.Setting.ESDiscovery),
+			elastic.SetURL(cfg.ESAddr),
+			elastic.SetSniff(cfg.ESDiscovery),
 		)
 	}
 	if err != nil {
@@ -44,6 +45,7 @@ func (e *Elasticsearch) setup() error {
 		BulkActions(1000).
 		BulkSize(2 << 20).
 		Stats(true).
+		After(e.after).
 		FlushInterval(10 * time.Second).
 		Do(e.ctx)
 	if err != nil {
@@ -63,6 +65,17 @@ func (e *Elasticsearch) setup() error {
 	return nil
 }
 
+// after counts the outcome of each bulk request.
+func (e *Elasticsearch) after(id int64, req []elastic.BulkableRequest, res *elastic.BulkResponse, err error) {
+	if err == nil && res != nil && res.Errors {
+		err = fmt.Errorf("%d of %d documents failed", len(res.Failed()), len(req))
+	}
+	if err != nil {
+		logp.Warn("elasticsearch bulk request %d: %v", id, err)
+	}
+	countPush("elasticsearch", err)
+}
+
 func (e *Elasticsearch) start(hCh chan *decoder.HEP) {
 
 	defer func() {
@@ -42,9 +42,9 @@ type Loki struct {
 }
 
 func (l *Loki) setup() error {
-	l.BatchSize = config.Setting.LokiBulk * 1024
-	l.BatchWait = time.Duration(config.Setting.LokiTimer) * time.Second
-	l.URL = config.Setting.LokiURL
+	l.BatchSize = config.Get().LokiBulk * 1024
+	l.BatchWait = time.Duration(config.Get().LokiTimer) * time.Second
+	l.URL = config.Get().LokiURL
 
 	u, err := url.Parse(l.URL)
 	if err != nil {
@@ -171,6 +171,7 @@ func (l *Loki) sendBatch(batch map[model.Fingerprint]*logproto.Stream) error {
 	defer cancel()
 
 	_, err = l.send(ctx, buf)
+	countPush("loki", err)
 	if err != nil {
 		return err
 	}
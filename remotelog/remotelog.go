@@ -1,13 +1,58 @@
 package remotelog
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sipcapture/heplify-server/decoder"
 )
 
+var pushes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "heplify_remotelog_push_total",
+	Help: "Loki and Elasticsearch pushes by result"},
+	[]string{"backend", "result"})
+
+// pushStatus is the outcome of the last push to a backend.
+type pushStatus struct {
+	lastSuccess time.Time
+	lastFailure time.Time
+	err         error
+}
+
+var (
+	pushMu    sync.Mutex
+	pushState = map[string]*pushStatus{}
+)
+
+func countPush(backend string, err error) {
+	pushMu.Lock()
+	s, ok := pushState[backend]
+	if !ok {
+		s = &pushStatus{}
+		pushState[backend] = s
+	}
+	if err != nil {
+		s.lastFailure, s.err = time.Now(), err
+	} else {
+		s.lastSuccess, s.err = time.Now(), nil
+	}
+	pushMu.Unlock()
+
+	if err != nil {
+		pushes.WithLabelValues(backend, "failure").Inc()
+	} else {
+		pushes.WithLabelValues(backend, "success").Inc()
+	}
+}
+
 type Remotelog struct {
 	H    RemoteHandler
 	Chan chan *decoder.HEP
+	name string
 }
 
 type RemoteHandler interface {
@@ -22,7 +67,8 @@ func New(name string) *Remotelog {
 	}
 
 	return &Remotelog{
-		H: register[name],
+		H:    register[name],
+		name: name,
 	}
 }
 
@@ -43,3 +89,23 @@ func (r *Remotelog) End() {
 	close(r.Chan)
 	logp.Info("close remotelog channel")
 }
+
+// LastPush returns the time of the last successful push.
+func (r *Remotelog) LastPush() time.Time {
+	pushMu.Lock()
+	defer pushMu.Unlock()
+	if s, ok := pushState[r.name]; ok {
+		return s.lastSuccess
+	}
+	return time.Time{}
+}
+
+// Health returns the error of the last push when it failed.
+func (r *Remotelog) Health() error {
+	pushMu.Lock()
+	defer pushMu.Unlock()
+	if s, ok := pushState[r.name]; ok && s.err != nil {
+		return fmt.Errorf("%s push failed at %s: %v", r.name, s.lastFailure.Format(time.RFC3339), s.err)
+	}
+	return nil
+}
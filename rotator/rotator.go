@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -42,37 +43,42 @@ type Rotator struct {
 	dropOnStart      bool
 	createJob        *cron.Cron
 	dropJob          *cron.Cron
+
+	mu      sync.Mutex
+	lastRun time.Time
+	lastErr error
 }
 
 func Setup(quit chan bool) *Rotator {
+	cfg := config.Get()
 	r := &Rotator{
 		quit:         quit,
-		user:         config.Setting.DBUser,
-		dataDB:       config.Setting.DBDataTable,
-		confDB:       config.Setting.DBConfTable,
-		driver:       config.Setting.DBDriver,
-		partLog:      setStep(config.Setting.DBPartLog),
-		partIsup:     setStep(config.Setting.DBPartIsup),
-		partQos:      setStep(config.Setting.DBPartQos),
-		partSip:      setStep(config.Setting.DBPartSip),
-		dropDays:     config.Setting.DBDropDays,
-		dropDaysCall: config.Setting.DBDropDaysCall,
-		dropOnStart:  config.Setting.DBDropOnStart,
+		user:         cfg.DBUser,
+		dataDB:       cfg.DBDataTable,
+		confDB:       cfg.DBConfTable,
+		driver:       cfg.DBDriver,
+		partLog:      setStep(cfg.DBPartLog),
+		partIsup:     setStep(cfg.DBPartIsup),
+		partQos:      setStep(cfg.DBPartQos),
+		partSip:      setStep(cfg.DBPartSip),
+		dropDays:     cfg.DBDropDays,
+		dropDaysCall: cfg.DBDropDaysCall,
+		dropOnStart:  cfg.DBDropOnStart,
 		createJob:    cron.New(),
 		dropJob:      cron.New(),
 	}
 
 	r.rootDBAddr, _ = database.ConnectString("")
-	r.confDBAddr, _ = database.ConnectString(config.Setting.DBConfTable)
-	r.dataDBAddr, _ = database.ConnectString(config.Setting.DBDataTable)
+	r.confDBAddr, _ = database.ConnectString(cfg.DBConfTable)
+	r.dataDBAddr, _ = database.ConnectString(cfg.DBDataTable)
 	if r.dropDaysCall == 0 {
 		r.dropDaysCall = r.dropDays
 	}
-	r.dropDaysRegister = config.Setting.DBDropDaysRegister
+	r.dropDaysRegister = cfg.DBDropDaysRegister
 	if r.dropDaysRegister == 0 {
 		r.dropDaysRegister = r.dropDays
 	}
-	r.dropDaysDefault = config.Setting.DBDropDaysDefault
+	r.dropDaysDefault = cfg.DBDropDaysDefault
 	if r.dropDaysDefault == 0 {
 		r.dropDaysDefault = r.dropDays
 	}
@@ -301,12 +307,16 @@ func (r *Rotator) Rotate() {
 	r.createTables()
 	_, err := r.createJob.AddFunc("30 03 * * *", func() {
 		logp.Info("run create job\n")
+		var failed error
 		if err := r.CreateDataTables(1); err != nil {
 			logp.Err("%v", err)
+			failed = err
 		}
 		if err := r.CreateDataTables(2); err != nil {
 			logp.Err("%v", err)
+			failed = err
 		}
+		r.record(failed)
 		logp.Info("finished create job, next will run at %v\n", time.Now().Add(time.Hour*24+1))
 	})
 	if err != nil {
@@ -317,9 +327,11 @@ func (r *Rotator) Rotate() {
 	if r.dropDays > 0 {
 		_, err := r.dropJob.AddFunc("45 03 * * *", func() {
 			logp.Info("run drop job\n")
-			if err := r.DropTables(); err != nil {
+			err := r.DropTables()
+			if err != nil {
 				logp.Err("%v", err)
 			}
+			r.record(err)
 			logp.Info("finished drop job, next will run at %v\n", time.Now().Add(time.Hour*24+1))
 		})
 		if err != nil {
@@ -334,26 +346,41 @@ func (r *Rotator) End() {
 	r.dropJob.Stop()
 }
 
+// Status returns the time and the error of the last create or drop run.
+func (r *Rotator) Status() (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastRun, r.lastErr
+}
+
+func (r *Rotator) record(err error) {
+	r.mu.Lock()
+	r.lastRun = time.Now()
+	r.lastErr = err
+	r.mu.Unlock()
+}
+
 func (r *Rotator) createTables() {
 	if r.user == "root" || r.user == "admin" || r.user == "postgres" {
 		if err := r.CreateDatabases(); err != nil {
 			logp.Info("%v", err)
+			r.record(err)
 			return
 		}
 	}
 	logp.Info("start creating tables (%v)\n", time.Now())
+	var failed error
 	if err := r.CreateConfTables(0); err != nil {
 		logp.Err("%v", err)
+		failed = err
 	}
-	if err := r.CreateDataTables(-1); err != nil {
-		logp.Err("%v", err)
-	}
-	if err := r.CreateDataTables(0); err != nil {
-		logp.Err("%v", err)
-	}
-	if err := r.CreateDataTables(1); err != nil {
-		logp.Err("%v", err)
+	for _, d := range []int{-1, 0, 1} {
+		if err := r.CreateDataTables(d); err != nil {
+			logp.Err("%v", err)
+			failed = err
+		}
 	}
+	r.record(failed)
 	logp.Info("end creating tables (%v)\n", time.Now())
 	if r.dropOnStart && r.dropDays != 0 {
 		if err := r.DropTables(); err != nil {
@@ -366,7 +393,8 @@ func (r *Rotator) createTables() {
 	}
 }
 
-func setStep(name string) (step int) {
+// Step returns the partition step in minutes of a DBPart* setting.
+func Step(name string) (step int, err error) {
 	switch name {
 	case "5m":
 		step = 5
@@ -391,12 +419,19 @@ func setStep(name string) (step int) {
 	case "24h", "1d":
 		step = 1440
 	default:
-		logp.Warn("Unallowed rotation step %s please use 5m or 1h steps", name)
-		step = 120
+		return 120, fmt.Errorf("unallowed rotation step %s please use 5m or 1h steps", name)
 	}
 	return
 }
 
+func setStep(name string) int {
+	step, err := Step(name)
+	if err != nil {
+		logp.Warn("%v", err)
+	}
+	return step
+}
+
 func checkDBErr(err error) bool {
 	if err != nil {
 		if mErr, ok := err.(*mysql.MySQLError); ok && (mErr.Number == 1050 ||
@@ -0,0 +1,318 @@
+package input
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
+)
+
+// adminMu serializes the changes of the admin API.
+var adminMu sync.Mutex
+
+var (
+	errInvalidToken = errors.New("invalid or missing bearer token")
+	errMethod       = errors.New("method not allowed")
+	errNoSettings   = errors.New("no settings given")
+)
+
+// adminFunc serves an admin request, e is written to the audit log by changes.
+type adminFunc func(h *HEPInput, w http.ResponseWriter, r *http.Request, e *auditEntry)
+
+type scriptState struct {
+	Engine string   `json:"engine"`
+	Folder string   `json:"folder"`
+	Files  []string `json:"files"`
+	Error  string   `json:"error,omitempty"`
+}
+
+type adminState struct {
+	*healthReport
+	Scripts *scriptState `json:"scripts,omitempty"`
+}
+
+type reloadResult struct {
+	Changed []string `json:"changed"`
+	Restart []string `json:"restart_required,omitempty"`
+	Warning string   `json:"warning,omitempty"`
+}
+
+type auditChange struct {
+	Setting string      `json:"setting"`
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+}
+
+// auditEntry is a line of the ConfigHTTPAudit file.
+type auditEntry struct {
+	Time    time.Time      `json:"time"`
+	User    string         `json:"user"`
+	Remote  string         `json:"remote"`
+	Action  string         `json:"action"`
+	Changes []*auditChange `json:"changes,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// adminUser returns the name of the ConfigHTTPToken entry matching the
+// bearer token of r. ConfigHTTPPW is accepted as token of the user admin.
+func adminUser(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+
+	tokens := config.Get().ConfigHTTPToken
+	if config.Get().ConfigHTTPPW != "" {
+		tokens = append(tokens[:len(tokens):len(tokens)], "admin="+config.Get().ConfigHTTPPW)
+	}
+	for _, t := range tokens {
+		i := strings.IndexByte(t, '=')
+		if i > 0 && subtle.ConstantTimeCompare([]byte(t[i+1:]), token) == 1 {
+			return t[:i], true
+		}
+	}
+	return "", false
+}
+
+// audit logs e and appends it to the ConfigHTTPAudit file.
+func audit(e *auditEntry) {
+	names := make([]string, len(e.Changes))
+	for i, c := range e.Changes {
+		names[i] = c.Setting
+	}
+	switch {
+	case e.Error != "":
+		logp.Warn("admin %s by %s from %s failed: %s", e.Action, e.User, e.Remote, e.Error)
+	case len(names) == 0:
+		logp.Info("admin %s by %s from %s without changed settings", e.Action, e.User, e.Remote)
+	default:
+		logp.Info("admin %s by %s from %s, changed settings: %s", e.Action, e.User, e.Remote, strings.Join(names, ", "))
+	}
+
+	path := config.Get().ConfigHTTPAudit
+	if path == "" {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		logp.Err("audit log: %v", err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logp.Err("audit log: %v", err)
+		return
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		logp.Err("audit log: %v", err)
+	}
+	f.Close()
+}
+
+// apply reloads the configuration after the optional update of the file
+// and writes the result to the audit log.
+func (h *HEPInput) apply(e *auditEntry, update func() error) (*reloadResult, error) {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+
+	old := config.Get().Masked()
+	res := &reloadResult{}
+	var err error
+	if update != nil {
+		err = update()
+	}
+	if err == nil {
+		res.Changed, res.Restart, err = h.reload()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	} else if update == nil {
+		cur := config.Get().Masked()
+		for _, name := range res.Changed {
+			e.Changes = append(e.Changes, &auditChange{name, field(&old, name), field(&cur, name)})
+		}
+	}
+	e.Time = time.Now()
+	audit(e)
+	return res, err
+}
+
+// patchConfig writes the settings of the request to the config file and
+// reloads it.
+func (h *HEPInput) patchConfig(w http.ResponseWriter, r *http.Request, e *auditEntry) {
+	var values map[string]json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&values); err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(values) == 0 {
+		adminError(w, http.StatusBadRequest, errNoSettings)
+		return
+	}
+	patch, err := config.Get().Patch(values)
+	if err != nil {
+		e.Time, e.Error = time.Now(), err.Error()
+		audit(e)
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := &auditChange{name, config.MaskedValue(name, field(config.Get(), name)), config.MaskedValue(name, patch[name])}
+		if !reflect.DeepEqual(c.Old, c.New) {
+			e.Changes = append(e.Changes, c)
+		}
+	}
+
+	path := config.Get().Config
+	var invalid bool
+	res, err := h.apply(e, func() error {
+		err := config.Update(path, patch, CheckOutputPolicies)
+		invalid = err != nil
+		return err
+	})
+	switch {
+	case invalid:
+		adminError(w, http.StatusBadRequest, err)
+	case err != nil:
+		adminError(w, reloadStatus(err), err)
+	default:
+		res.Warning = fmt.Sprintf("%s was rewritten without its comments", path)
+		adminJSON(w, http.StatusOK, res)
+	}
+}
+
+func (h *HEPInput) scripts() *scriptState {
+	if !config.Get().ScriptEnable {
+		return nil
+	}
+	s := &scriptState{Engine: config.Get().ScriptEngine, Folder: config.Get().ScriptFolder, Files: []string{}}
+	s.Error, _ = h.scriptErr.Load().(string)
+	if dir, err := ioutil.ReadDir(s.Folder); err == nil {
+		for _, fi := range dir {
+			if ext := filepath.Ext(fi.Name()); !fi.IsDir() && (ext == ".lua" || ext == ".expr") {
+				s.Files = append(s.Files, fi.Name())
+			}
+		}
+	}
+	return s
+}
+
+// AdminHandler serves the JSON admin API on ConfigHTTPAddr. All requests
+// need a ConfigHTTPToken as bearer token.
+//
+//	GET   /api/v1/config  effective configuration with masked secrets
+//	PATCH /api/v1/config  change settings in the config file and reload it
+//	POST  /api/v1/reload  reload the config file like SIGHUP
+//	GET   /api/v1/state   listeners, outputs, rotator and scripts
+func AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(path string, methods map[string]adminFunc) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			user, ok := adminUser(r)
+			if !ok {
+				logp.Warn("admin %s %s from %s: invalid token", r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="heplify-server"`)
+				adminError(w, http.StatusUnauthorized, errInvalidToken)
+				return
+			}
+			serve, ok := methods[r.Method]
+			if !ok {
+				allow := make([]string, 0, len(methods))
+				for m := range methods {
+					allow = append(allow, m)
+				}
+				sort.Strings(allow)
+				w.Header().Set("Allow", strings.Join(allow, ", "))
+				adminError(w, http.StatusMethodNotAllowed, errMethod)
+				return
+			}
+			h, _ := activeInput.Load().(*HEPInput)
+			if h == nil {
+				adminError(w, http.StatusServiceUnavailable, errStarting)
+				return
+			}
+			serve(h, w, r, &auditEntry{User: user, Remote: r.RemoteAddr, Action: r.Method + " " + path})
+		})
+	}
+
+	handle("/api/v1/config", map[string]adminFunc{
+		http.MethodGet: func(h *HEPInput, w http.ResponseWriter, r *http.Request, e *auditEntry) {
+			adminJSON(w, http.StatusOK, config.Get().Masked())
+		},
+		http.MethodPatch: func(h *HEPInput, w http.ResponseWriter, r *http.Request, e *auditEntry) {
+			select {
+			case <-h.ready:
+				h.patchConfig(w, r, e)
+			default:
+				adminError(w, http.StatusServiceUnavailable, errStarting)
+			}
+		},
+	})
+	handle("/api/v1/reload", map[string]adminFunc{
+		http.MethodPost: func(h *HEPInput, w http.ResponseWriter, r *http.Request, e *auditEntry) {
+			res, err := h.apply(e, nil)
+			if err != nil {
+				adminError(w, reloadStatus(err), err)
+				return
+			}
+			adminJSON(w, http.StatusOK, res)
+		},
+	})
+	handle("/api/v1/state", map[string]adminFunc{
+		http.MethodGet: func(h *HEPInput, w http.ResponseWriter, r *http.Request, e *auditEntry) {
+			report, _ := h.health(true)
+			adminJSON(w, http.StatusOK, &adminState{healthReport: report, Scripts: h.scripts()})
+		},
+	})
+	return mux
+}
+
+func reloadStatus(err error) int {
+	if err == errStarting || err == ErrStopping {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func adminJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminError answers with the error and, for config errors, every problem.
+func adminError(w http.ResponseWriter, code int, err error) {
+	res := struct {
+		Error  string   `json:"error"`
+		Errors []string `json:"errors,omitempty"`
+	}{Error: err.Error()}
+	if errs, ok := err.(config.Errors); ok {
+		for _, err := range errs {
+			res.Errors = append(res.Errors, err.Error())
+		}
+	}
+	adminJSON(w, code, &res)
+}
+
+func field(cfg *config.HeplifyServer, name string) interface{} {
+	return reflect.ValueOf(cfg).Elem().FieldByName(name).Interface()
+}
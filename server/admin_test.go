@@ -0,0 +1,115 @@
+package input
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer config.Set(nil)
+
+	path := filepath.Join(dir, "heplify-server.toml")
+	s := `HEPAddr = ""` + "\n" + `PromAddr = ""` + "\n" + `DBAddr = ""` + "\n" + `DBPass = "secret"` + "\n" +
+		`ConfigHTTPToken = ["ops=abc"]` + "\n" + `ConfigHTTPAudit = "` + filepath.Join(dir, "audit.log") + `"`
+	if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Config = path
+	config.Set(cfg)
+
+	h := NewHEPInput()
+	h.startWorkers()
+	close(h.ready)
+	activeInput.Store(h)
+	defer activeInput.Store((*HEPInput)(nil))
+	defer func() { h.stopWorker() }()
+
+	handler := AdminHandler()
+	do := func(method, target, token string, body io.Reader) (int, map[string]interface{}) {
+		r := httptest.NewRequest(method, target, body)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		res := make(map[string]interface{})
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		return w.Code, res
+	}
+
+	code, _ := do(http.MethodGet, "/api/v1/config", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = do(http.MethodGet, "/api/v1/config", "abd", nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = do(http.MethodDelete, "/api/v1/config", "abc", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	code, res := do(http.MethodGet, "/api/v1/config", "abc", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "<private>", res["DBPass"])
+	assert.Equal(t, []interface{}{"ops=<private>"}, res["ConfigHTTPToken"])
+
+	code, res = do(http.MethodPatch, "/api/v1/config", "abc", strings.NewReader(`{"DBBulk":"many","DBShma":"homer7"}`))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Len(t, res["errors"], 2)
+
+	code, res = do(http.MethodPatch, "/api/v1/config", "abc", strings.NewReader(`{"DBBulk":200,"DBPass":"<private>","LogLvl":"debug"}`))
+	if assert.Equal(t, http.StatusOK, code, res) {
+		assert.Equal(t, []interface{}{"DBBulk"}, res["changed"])
+		assert.Contains(t, res["restart_required"], "LogLvl")
+		assert.Contains(t, res["warning"], "without its comments")
+	}
+	assert.Equal(t, 200, config.Get().DBBulk)
+	assert.Equal(t, "secret", config.Get().DBPass)
+
+	code, res = do(http.MethodPost, "/api/v1/reload", "abc", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, res["changed"])
+
+	code, res = do(http.MethodGet, "/api/v1/state", "abc", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, res, "listeners")
+
+	f, err := os.Open(config.Get().ConfigHTTPAudit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []*auditEntry
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		e := &auditEntry{}
+		assert.NoError(t, json.Unmarshal(sc.Bytes(), e))
+		entries = append(entries, e)
+	}
+	if assert.Len(t, entries, 3) {
+		assert.NotEmpty(t, entries[0].Error)
+		assert.Equal(t, "ops", entries[1].User)
+		assert.Equal(t, "PATCH /api/v1/config", entries[1].Action)
+		assert.Empty(t, entries[1].Error)
+		if assert.Len(t, entries[1].Changes, 2) {
+			assert.Equal(t, &auditChange{"DBBulk", 400.0, 200.0}, entries[1].Changes[0])
+			assert.Equal(t, &auditChange{"LogLvl", "info", "debug"}, entries[1].Changes[1])
+		}
+		assert.Equal(t, "POST /api/v1/reload", entries[2].Action)
+	}
+}
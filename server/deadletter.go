@@ -0,0 +1,273 @@
+package input
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+const (
+	deadLetterExt      = ".ndjson"
+	deadLetterMinFile  = 64 << 10
+	deadLetterMaxLimit = 1000
+	deadLetterQueue    = 1000
+)
+
+// deadLetter is a packet which could not be decoded.
+type deadLetter struct {
+	Time     time.Time `json:"time"`
+	Listener string    `json:"listener"`
+	Source   string    `json:"source,omitempty"`
+	Class    string    `json:"class"`
+	Reason   string    `json:"reason"`
+	Raw      []byte    `json:"raw"`
+}
+
+// deadLetterDir writes undecodable packets as newline delimited JSON into
+// a directory. A new file is started after a tenth of maxSize and the
+// oldest files are removed when the directory grows above maxSize.
+// The packets are written by a goroutine, packets which don't fit into
+// its queue are dropped.
+type deadLetterDir struct {
+	mu       sync.Mutex // guards the files of dir against list
+	dir      string
+	maxSize  int64
+	fileSize int64
+	file     *os.File
+	written  int64
+	ch       chan *deadLetter
+	done     chan struct{}
+	closed   chan struct{}
+}
+
+func newDeadLetterDir(dir string, maxSize int64) (*deadLetterDir, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("dead letter directory: %v", err)
+	}
+	fileSize := maxSize / 10
+	if fileSize < deadLetterMinFile {
+		fileSize = deadLetterMinFile
+	}
+	d := &deadLetterDir{
+		dir:      dir,
+		maxSize:  maxSize,
+		fileSize: fileSize,
+		ch:       make(chan *deadLetter, deadLetterQueue),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go d.run()
+	return d, nil
+}
+
+// add queues the packet of in. It returns false when the queue is full.
+func (d *deadLetterDir) add(in inputPkt, err error) bool {
+	l := &deadLetter{
+		Time:     time.Now(),
+		Listener: in.proto,
+		Class:    decoder.ErrorClass(err),
+		Reason:   err.Error(),
+		Raw:      append([]byte(nil), in.buf...),
+	}
+	if in.src != nil {
+		l.Source = in.src.String()
+	}
+	select {
+	case <-d.done:
+		return true
+	case d.ch <- l:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *deadLetterDir) run() {
+	defer close(d.closed)
+	for {
+		select {
+		case l := <-d.ch:
+			d.write(l)
+		case <-d.done:
+			for {
+				select {
+				case l := <-d.ch:
+					d.write(l)
+				default:
+					if d.file != nil {
+						d.file.Close()
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *deadLetterDir) write(l *deadLetter) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		logp.Warn("dead letter: %v", err)
+		return
+	}
+	b = append(b, '\n')
+
+	if d.file == nil || d.written+int64(len(b)) > d.fileSize {
+		if err = d.rotate(); err != nil {
+			logp.Warn("dead letter: %v", err)
+			return
+		}
+	}
+	n, err := d.file.Write(b)
+	d.written += int64(n)
+	if err != nil {
+		logp.Warn("dead letter: %v", err)
+	}
+}
+
+// rotate starts a new file and removes the oldest ones above maxSize.
+func (d *deadLetterDir) rotate() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file != nil {
+		d.file.Close()
+		d.file = nil
+	}
+	name := filepath.Join(d.dir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), deadLetterExt))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	d.file, d.written = f, 0
+
+	files, err := d.files()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for i := len(files) - 1; i >= 0; i-- {
+		total += files[i].Size()
+		if total > d.maxSize-d.fileSize && filepath.Join(d.dir, files[i].Name()) != name {
+			os.Remove(filepath.Join(d.dir, files[i].Name()))
+		}
+	}
+	return nil
+}
+
+// files returns the dead letter files from old to new.
+func (d *deadLetterDir) files() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := infos[:0]
+	for _, fi := range infos {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), deadLetterExt) {
+			files = append(files, fi)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+// list returns up to limit dead letters of class, or of all classes
+// when class is empty, newest first.
+func (d *deadLetterDir) list(limit int, class string) ([]*deadLetter, error) {
+	d.mu.Lock()
+	files, err := d.files()
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*deadLetter{}
+	for i := len(files) - 1; i >= 0 && len(list) < limit; i-- {
+		f, err := os.Open(filepath.Join(d.dir, files[i].Name()))
+		if os.IsNotExist(err) {
+			// removed by a rotation since the snapshot
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var letters []*deadLetter
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 4096), 4*maxPktLen)
+		for sc.Scan() {
+			l := &deadLetter{}
+			if json.Unmarshal(sc.Bytes(), l) != nil {
+				// skip lines which were cut by a crash
+				continue
+			}
+			if class == "" || l.Class == class {
+				letters = append(letters, l)
+			}
+		}
+		f.Close()
+		for j := len(letters) - 1; j >= 0 && len(list) < limit; j-- {
+			list = append(list, letters[j])
+		}
+	}
+	return list, nil
+}
+
+// close writes the queued packets and closes the current file.
+func (d *deadLetterDir) close() {
+	close(d.done)
+	<-d.closed
+}
+
+// DeadLetterHandler lists the newest undecodable packets as JSON. The
+// query parameters limit and class narrow the result. Like the admin API
+// it needs a ConfigHTTPToken as bearer token.
+func DeadLetterHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := adminUser(r); !ok {
+			logp.Warn("%s %s from %s: invalid token", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="heplify-server"`)
+			http.Error(w, errInvalidToken.Error(), http.StatusUnauthorized)
+			return
+		}
+		h, _ := activeInput.Load().(*HEPInput)
+		var d *deadLetterDir
+		if h != nil {
+			h.mu.RLock()
+			d = h.deadLetter
+			h.mu.RUnlock()
+		}
+		if d == nil {
+			http.Error(w, "dead letters are disabled", http.StatusNotFound)
+			return
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if limit > deadLetterMaxLimit {
+			limit = deadLetterMaxLimit
+		}
+		list, err := d.list(limit, r.URL.Query().Get("class"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	})
+}
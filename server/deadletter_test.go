@@ -0,0 +1,93 @@
+package input
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := newDeadLetterDir(dir, 10*deadLetterMinFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := []byte("HEP3 garbage")
+	_, decodeErr := decoder.DecodeHEP(raw)
+	assert.True(t, d.add(inputPkt{buf: raw, src: net.ParseIP("10.0.0.1"), proto: "udp"}, decodeErr))
+	// close writes the queued packets
+	d.close()
+	if d, err = newDeadLetterDir(dir, 10*deadLetterMinFile); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := config.Setting.ConfigHTTPToken
+	config.Setting.ConfigHTTPToken = []string{"ops=abc"}
+	defer func() { config.Setting.ConfigHTTPToken = saved }()
+	activeInput.Store(&HEPInput{deadLetter: d})
+	defer activeInput.Store((*HEPInput)(nil))
+	w := httptest.NewRecorder()
+	DeadLetterHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/deadletter", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/deadletter?class=bad_length", nil)
+	r.Header.Set("Authorization", "Bearer abc")
+	DeadLetterHandler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list []*deadLetter
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, raw, list[0].Raw)
+		assert.Equal(t, "10.0.0.1", list[0].Source)
+		assert.Equal(t, "udp", list[0].Listener)
+		assert.Equal(t, decoder.ClassLength, list[0].Class)
+		assert.NotEmpty(t, list[0].Reason)
+	}
+
+	// fill the directory several times over, the oldest files are removed
+	big := make([]byte, 4096)
+	for i := 0; i < 1000; i++ {
+		big[0] = byte(i)
+		for !d.add(inputPkt{buf: big, proto: "tcp"}, decodeErr) {
+			runtime.Gosched()
+		}
+	}
+	d.close()
+	if d, err = newDeadLetterDir(dir, 10*deadLetterMinFile); err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+	files, err := d.files()
+	assert.NoError(t, err)
+	var total int64
+	for _, fi := range files {
+		total += fi.Size()
+	}
+	assert.True(t, total <= d.maxSize, "directory size %d exceeds %d", total, d.maxSize)
+
+	list, err = d.list(2, "")
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, byte(999%256), list[0].Raw[0])
+		assert.Equal(t, byte(998%256), list[1].Raw[0])
+	}
+	list, err = d.list(10, decoder.ClassNoCSeq)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+}
@@ -0,0 +1,230 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+var hep3Magic = []byte(decoder.HEP3Magic)
+
+// framer splits a stream into HEP packets. It understands the HEP3 length
+// framing and varint length prefixed protobuf messages. After garbage it
+// skips forward to the next HEP3 header.
+type framer struct {
+	r        *bufio.Reader
+	resync   bool
+	errCount uint64
+	stats    *uint64
+}
+
+func newFramer(r io.Reader, stats *uint64) *framer {
+	return &framer{r: bufio.NewReader(r), stats: stats}
+}
+
+// next reads the next packet into buf. Framing errors are counted and
+// skipped, only read errors are returned.
+func (f *framer) next(buf []byte) ([]byte, error) {
+	for {
+		if f.resync {
+			if err := f.skipToMagic(); err != nil {
+				return nil, err
+			}
+			f.resync = false
+		}
+
+		hb, err := f.r.Peek(6)
+		if err != nil {
+			return nil, err
+		}
+
+		var size int
+		if decoder.IsHEP3(hb) {
+			size = int(binary.BigEndian.Uint16(hb[4:6]))
+			if size < 6 {
+				f.fail("HEP3 packet length %d", size)
+				continue
+			}
+		} else {
+			// protobuf messages start with the Version field tag 0x08
+			l, n := binary.Uvarint(hb)
+			if n <= 0 || n >= len(hb) || hb[n] != 0x08 || l == 0 {
+				f.fail("unknown packet header %x", hb)
+				continue
+			}
+			size = int(l)
+			if size <= len(buf) {
+				f.r.Discard(n)
+			}
+		}
+
+		if size > len(buf) {
+			f.fail("packet length %d", size)
+			continue
+		}
+		if _, err = io.ReadFull(f.r, buf[:size]); err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
+
+func (f *framer) fail(format string, args ...interface{}) {
+	f.errCount++
+	pktError.Inc()
+	if f.stats != nil {
+		atomic.AddUint64(f.stats, 1)
+	}
+	if f.errCount == 1 {
+		logp.Warn("invalid %s, resync stream", fmt.Sprintf(format, args...))
+	}
+	f.r.Discard(1)
+	f.resync = true
+}
+
+func (f *framer) skipToMagic() error {
+	for {
+		b, err := f.r.Peek(len(hep3Magic))
+		if err != nil {
+			return err
+		}
+		if decoder.IsHEP3(b) {
+			return nil
+		}
+		buffered, _ := f.r.Peek(f.r.Buffered())
+		if i := bytes.Index(buffered, hep3Magic); i > 0 {
+			f.r.Discard(i)
+			return nil
+		}
+		// keep the tail which could be the beginning of the next header
+		n := len(buffered) - len(hep3Magic) + 1
+		if n < 1 {
+			n = 1
+		}
+		f.r.Discard(n)
+	}
+}
+
+// handleStream reads HEP packets from TCP, TLS and WS connections.
+// A non empty nodeName overwrites the NodeName of all packets.
+func (h *HEPInput) handleStream(c net.Conn, r io.Reader, proto, nodeName string, stop *uint32) {
+	f := newFramer(r, &h.stats.ErrCount)
+	src := remoteIP(c.RemoteAddr())
+	listener := strings.ToLower(proto)
+	var pktCount uint64
+	defer func() {
+		logp.Info("closing %s connection from %s after %d packets and %d framing errors",
+			proto, c.RemoteAddr(), pktCount, f.errCount)
+		err := c.Close()
+		if err != nil {
+			logp.Err("%v", err)
+		}
+	}()
+
+	// unblock the read of an idle connection when the listener stops
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if h.stopping(stop) {
+					c.SetReadDeadline(time.Now())
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		if h.stopping(stop) {
+			return
+		}
+
+		buf := h.buffer.Get().([]byte)
+		pkt, err := f.next(buf)
+		if err != nil {
+			h.buffer.Put(buf)
+			if err != io.EOF && !h.stopping(stop) {
+				logp.Warn("%v from %s", err, c.RemoteAddr())
+			}
+			return
+		}
+		h.inputCh <- inputPkt{buf: pkt, src: src, proto: listener, nodeName: nodeName}
+		pktCount++
+		atomic.AddUint64(&h.stats.PktCount, 1)
+		pktReceived.Inc()
+	}
+}
+
+// wsReader returns the payload of binary WebSocket messages as one stream.
+// Fragmented messages and control frames are handled, text messages are dropped.
+type wsReader struct {
+	rd      *wsutil.Reader
+	control wsutil.FrameHandlerFunc
+	inMsg   bool
+}
+
+func newWSReader(c net.Conn) *wsReader {
+	control := wsutil.ControlFrameHandler(c, ws.StateServerSide)
+	return &wsReader{
+		rd: &wsutil.Reader{
+			Source:         c,
+			State:          ws.StateServerSide,
+			OnIntermediate: control,
+		},
+		control: control,
+	}
+}
+
+func (w *wsReader) Read(p []byte) (int, error) {
+	for {
+		if w.inMsg {
+			n, err := w.rd.Read(p)
+			if err == io.EOF {
+				w.inMsg = false
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		hdr, err := w.rd.NextFrame()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case hdr.OpCode.IsControl():
+			if err = w.control(hdr, w.rd); err != nil {
+				if _, ok := err.(wsutil.ClosedError); ok {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+		case hdr.OpCode == ws.OpText:
+			logp.Debug("ws", "drop text message with %d bytes", hdr.Length)
+			if err = w.rd.Discard(); err != nil {
+				return 0, err
+			}
+		default:
+			w.inMsg = true
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gobwas/ws"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFramer(t *testing.T) {
+	pb, err := (&decoder.HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2",
+		ProtoType: 100, Payload: "log"}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, uint64(len(pb)))
+
+	var stream bytes.Buffer
+	stream.Write(hepPacket)
+	stream.Write(prefix[:n])
+	stream.Write(pb)
+	stream.WriteString("garbage")
+	stream.Write(hepPacket)
+
+	var errCount uint64
+	// deliver the stream byte by byte to simulate split records
+	f := newFramer(iotest.OneByteReader(&stream), &errCount)
+	buf := make([]byte, maxPktLen)
+
+	pkt, err := f.next(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, hepPacket, pkt)
+
+	pkt, err = f.next(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, pb, pkt)
+
+	pkt, err = f.next(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, hepPacket, pkt)
+	assert.Equal(t, uint64(1), errCount)
+
+	_, err = f.next(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWSReader(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	// masking works in place, so don't touch hepPacket
+	pkt := append([]byte(nil), hepPacket...)
+	pkt2 := append([]byte(nil), hepPacket...)
+
+	go func() {
+		for _, f := range []ws.Frame{
+			ws.NewFrame(ws.OpBinary, false, pkt[:100]),
+			ws.NewPingFrame([]byte("ping")),
+			ws.NewFrame(ws.OpContinuation, true, pkt[100:]),
+			ws.NewTextFrame([]byte("hello")),
+			ws.NewBinaryFrame(pkt2),
+			ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusNormalClosure, "")),
+		} {
+			ws.WriteFrame(client, ws.MaskFrameInPlace(f))
+		}
+	}()
+	go io.Copy(ioutil.Discard, client)
+
+	f := newFramer(newWSReader(server), nil)
+	buf := make([]byte, maxPktLen)
+	for i := 0; i < 2; i++ {
+		pkt, err := f.next(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, hepPacket, pkt)
+	}
+	_, err := f.next(buf)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, uint64(0), f.errCount)
+}
@@ -0,0 +1,201 @@
+package input
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// hepIngestDesc describes the service of ingest.proto. The HEP messages
+// are not decoded here but passed as protobuf to the workers.
+var hepIngestDesc = grpc.ServiceDesc{
+	ServiceName: "decoder.HEPIngest",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Stream",
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(*grpcIngest).stream(stream)
+		},
+	}},
+	Metadata: "ingest.proto",
+}
+
+// rawCodec hands the message bytes through without unmarshaling them.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case *[]byte:
+		return *b, nil
+	}
+	return nil, fmt.Errorf("raw codec can't marshal %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec can't unmarshal into %T", v)
+	}
+	*b = data
+	return nil
+}
+
+func (rawCodec) Name() string   { return "proto" }
+func (rawCodec) String() string { return "proto" }
+
+// encodeAck returns the protobuf encoded Ack message.
+func encodeAck(received uint64) []byte {
+	b := make([]byte, 1+binary.MaxVarintLen64)
+	b[0] = 0x08
+	return b[:1+binary.PutUvarint(b[1:], received)]
+}
+
+type grpcIngest struct {
+	h        *HEPInput
+	mu       sync.Mutex
+	stopped  bool
+	handlers sync.WaitGroup
+}
+
+// begin counts a new stream handler unless the listener is stopping.
+func (g *grpcIngest) begin() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return false
+	}
+	g.handlers.Add(1)
+	return true
+}
+
+// stop refuses new streams and waits for the running handlers.
+func (g *grpcIngest) stop() {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+	g.handlers.Wait()
+}
+
+func (g *grpcIngest) stream(stream grpc.ServerStream) error {
+	if !g.begin() {
+		return status.Error(codes.Unavailable, "heplify-server is stopping")
+	}
+	defer g.handlers.Done()
+
+	ctx := stream.Context()
+	if err := checkGRPCToken(ctx); err != nil {
+		return err
+	}
+	var src net.IP
+	var nodeName string
+	if p, ok := peer.FromContext(ctx); ok {
+		src = remoteIP(p.Addr)
+		if ti, ok := p.AuthInfo.(credentials.TLSInfo); ok && config.Get().TLSCertNodeName {
+			nodeName = certNodeName(ti.State)
+		}
+	}
+
+	h := g.h
+	var received uint64
+	for {
+		var msg []byte
+		err := stream.RecvMsg(&msg)
+		if err == io.EOF {
+			return stream.SendMsg(encodeAck(received))
+		} else if err != nil {
+			return err
+		}
+		if atomic.LoadUint32(&h.stopped) == 1 {
+			return status.Error(codes.Unavailable, "heplify-server is stopping")
+		}
+		if len(msg) == 0 || len(msg) > maxPktLen {
+			atomic.AddUint64(&h.stats.ErrCount, 1)
+			pktError.Inc()
+			continue
+		}
+		buf := h.buffer.Get().([]byte)
+		h.inputCh <- inputPkt{buf: buf[:copy(buf, msg)], src: src, proto: "grpc", nodeName: nodeName}
+		atomic.AddUint64(&h.stats.PktCount, 1)
+		pktReceived.Inc()
+		received++
+	}
+}
+
+// checkGRPCToken compares the bearer token of the authorization metadata
+// with HEPGRPCToken.
+func checkGRPCToken(ctx context.Context) error {
+	token := config.Get().HEPGRPCToken
+	if token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if !validToken(md.Get("authorization"), token) {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return nil
+}
+
+func (h *HEPInput) newGRPCServer() (*grpc.Server, *grpcIngest) {
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(rawCodec{})}
+	if config.Get().HEPGRPCTLS {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(h.tlsCerts.dynamicConfig("h2"))))
+	}
+	g := &grpcIngest{h: h}
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&hepIngestDesc, g)
+	return srv, g
+}
+
+func (h *HEPInput) serveGRPC(addr string) {
+	defer h.listenWg.Done()
+	stop := h.listeners.stopFlag("grpc", addr)
+
+	if config.Get().HEPGRPCTLS {
+		if err := h.tlsCerts.load(); err != nil {
+			logp.Err("%v", err)
+			h.listeners.set("grpc", addr, err)
+			return
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logp.Err("%v", err)
+		h.listeners.set("grpc", addr, err)
+		return
+	}
+	h.listeners.set("grpc", addr, nil)
+	defer h.listeners.set("grpc", addr, errListenerClosed)
+
+	srv, g := h.newGRPCServer()
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			logp.Err("%v", err)
+		}
+	}()
+
+	for !h.stopping(stop) {
+		time.Sleep(time.Second)
+	}
+	logp.Info("stopping gRPC listener on %s", ln.Addr())
+	srv.Stop()
+	// Stop doesn't wait for the handlers which may still send to inputCh
+	g.stop()
+}
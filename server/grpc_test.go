@@ -0,0 +1,91 @@
+package input
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCIngest(t *testing.T) {
+	cfg := config.Setting
+	cfg.HEPGRPCToken = "secret"
+	config.Set(&cfg)
+	defer config.Set(nil)
+
+	h := &HEPInput{
+		inputCh: make(chan inputPkt, 10),
+		buffer:  &sync.Pool{New: func() interface{} { return make([]byte, maxPktLen) }},
+	}
+	srv, g := h.newGRPCServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	pkt := &decoder.HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2", ProtoType: 100, NodeID: 7, Payload: "hello"}
+	msg, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(token string) (uint64, error) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+		stream, err := conn.NewStream(ctx, &hepIngestDesc.Streams[0], "/decoder.HEPIngest/Stream", grpc.ForceCodec(rawCodec{}))
+		if err != nil {
+			return 0, err
+		}
+		for i := 0; i < 3; i++ {
+			if err = stream.SendMsg(msg); err != nil {
+				break
+			}
+		}
+		stream.CloseSend()
+		var ack []byte
+		if err = stream.RecvMsg(&ack); err != nil {
+			return 0, err
+		}
+		if len(ack) < 2 || ack[0] != 0x08 {
+			return 0, fmt.Errorf("invalid ack %x", ack)
+		}
+		received, _ := binary.Uvarint(ack[1:])
+		return received, nil
+	}
+
+	_, err = send("wrong")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	received, err := send("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), received)
+	for i := 0; i < 3; i++ {
+		in := <-h.inputCh
+		out, err := decoder.DecodeHEP(in.buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", out.Payload)
+		assert.Equal(t, "127.0.0.1", in.src.String())
+	}
+
+	// streams which start after the stop are refused
+	g.stop()
+	_, err = send("secret")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
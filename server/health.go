@@ -0,0 +1,192 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// queueSaturated is the channel fill level from which an instance is not ready.
+const queueSaturated = 0.9
+
+var (
+	errListenerStarting = errors.New("listener not bound yet")
+	errListenerClosed   = errors.New("listener closed")
+)
+
+// activeInput is the running HEPInput which answers the health requests.
+var activeInput atomic.Value // *HEPInput
+
+// HealthChecker is implemented by outputs which can check their backend.
+type HealthChecker interface {
+	Health() error
+}
+
+// PushTracker is implemented by outputs which push batches to a remote backend.
+type PushTracker interface {
+	LastPush() time.Time
+}
+
+type listenerState struct {
+	Proto string `json:"proto"`
+	Addr  string `json:"addr"`
+	Bound bool   `json:"bound"`
+	Error string `json:"error,omitempty"`
+}
+
+type outputState struct {
+	Name       string     `json:"name"`
+	Healthy    bool       `json:"healthy"`
+	Error      string     `json:"error,omitempty"`
+	LastPush   *time.Time `json:"last_push,omitempty"`
+	Queue      int        `json:"queue"`
+	Capacity   int        `json:"capacity"`
+	Saturation float64    `json:"saturation"`
+}
+
+type rotatorState struct {
+	LastRun *time.Time `json:"last_run,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status    string           `json:"status"`
+	Listeners []*listenerState `json:"listeners"`
+	Input     *outputState     `json:"input,omitempty"`
+	Outputs   []*outputState   `json:"outputs,omitempty"`
+	Rotator   *rotatorState    `json:"rotator,omitempty"`
+	Restart   []string         `json:"restart_required,omitempty"`
+}
+
+// listeners tracks the bind state of all HEP listeners.
+type listeners struct {
+	mu    sync.Mutex
+	state map[string]*listenerState
+	ctl   map[string]*listenerCtl
+}
+
+func (l *listeners) set(proto, addr string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state == nil {
+		l.state = make(map[string]*listenerState)
+	}
+	s := &listenerState{Proto: proto, Addr: addr, Bound: err == nil}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	l.state[proto+" "+addr] = s
+}
+
+func (l *listeners) list() []*listenerState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]*listenerState, 0, len(l.state))
+	for _, s := range l.state {
+		c := *s
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Proto+list[i].Addr < list[j].Proto+list[j].Addr
+	})
+	return list
+}
+
+func queueState(name string, depth, capacity int) *outputState {
+	s := &outputState{Name: name, Healthy: true, Queue: depth, Capacity: capacity}
+	if capacity > 0 {
+		s.Saturation = float64(depth) / float64(capacity)
+	}
+	if s.Saturation >= queueSaturated {
+		s.Healthy = false
+		s.Error = "channel saturated"
+	}
+	return s
+}
+
+// health checks the listeners and, with ready, also the outputs and the rotator.
+// Liveness only fails when a listener could not bind.
+func (h *HEPInput) health(ready bool) (*healthReport, bool) {
+	ok := true
+	r := &healthReport{Listeners: h.listeners.list()}
+	for _, l := range r.Listeners {
+		if !l.Bound {
+			ok = false
+		}
+	}
+
+	r.Restart, _ = h.pending.Load().([]string)
+
+	if ready {
+		h.mu.RLock()
+		sinks, rot := h.sinks, h.rotator
+		h.mu.RUnlock()
+
+		r.Input = queueState("input", len(h.inputCh), cap(h.inputCh))
+		ok = ok && r.Input.Healthy
+		for _, s := range sinks {
+			o := queueState(s.name, len(s.ch), cap(s.ch))
+			if err, _ := s.runErr.Load().(string); err != "" {
+				o.Healthy, o.Error = false, err
+			} else if c, isChecker := s.out.(HealthChecker); isChecker {
+				if err := c.Health(); err != nil {
+					o.Healthy, o.Error = false, err.Error()
+				}
+			}
+			if p, isTracker := s.out.(PushTracker); isTracker {
+				if t := p.LastPush(); !t.IsZero() {
+					o.LastPush = &t
+				}
+			}
+			ok = ok && o.Healthy
+			r.Outputs = append(r.Outputs, o)
+		}
+
+		if rot != nil {
+			t, err := rot.Status()
+			r.Rotator = &rotatorState{}
+			if !t.IsZero() {
+				r.Rotator.LastRun = &t
+			}
+			if err != nil {
+				r.Rotator.Error = err.Error()
+				ok = false
+			}
+		}
+	}
+
+	r.Status = "ok"
+	if !ok {
+		r.Status = "fail"
+	}
+	return r, ok
+}
+
+// HealthHandler serves /healthz and /readyz as JSON. Both answer with
+// 503 until the server runs and whenever a check fails.
+func HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	serve := func(ready bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			h, _ := activeInput.Load().(*HEPInput)
+			if h == nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(&healthReport{Status: "starting"})
+				return
+			}
+			report, ok := h.health(ready)
+			if !ok {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(report)
+		}
+	}
+	mux.HandleFunc("/healthz", serve(false))
+	mux.HandleFunc("/readyz", serve(true))
+	return mux
+}
@@ -0,0 +1,69 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOutput struct{ err error }
+
+func (o *fakeOutput) Run(chan *decoder.HEP) error { return nil }
+func (o *fakeOutput) End()                        {}
+func (o *fakeOutput) Health() error               { return o.err }
+
+func TestHealthHandler(t *testing.T) {
+	activeInput.Store((*HEPInput)(nil))
+	defer activeInput.Store((*HEPInput)(nil))
+	handler := HealthHandler()
+	get := func(path string) (int, *healthReport) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		r := &healthReport{}
+		if err := json.NewDecoder(w.Body).Decode(r); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, r
+	}
+
+	code, _ := get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	out := &fakeOutput{}
+	h := &HEPInput{
+		inputCh: make(chan inputPkt, 10),
+		sinks:   []*sink{{name: "database", ch: make(chan *decoder.HEP, 10), out: out}},
+	}
+	h.listeners.set("udp", "0.0.0.0:9060", nil)
+	activeInput.Store(h)
+
+	code, r := get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", r.Status)
+	assert.Equal(t, 1, len(r.Outputs))
+
+	out.err = errors.New("connection refused")
+	code, r = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "connection refused", r.Outputs[0].Error)
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	out.err = nil
+	for i := 0; i < 9; i++ {
+		h.inputCh <- inputPkt{}
+	}
+	code, r = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, r.Input.Healthy)
+
+	h.listeners.set("udp", "0.0.0.0:9060", errors.New("address already in use"))
+	code, r = get("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, r.Listeners[0].Bound)
+}
@@ -0,0 +1,225 @@
+package input
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+const httpIngestPath = "/api/v1/hep"
+
+// httpResult is the response of a batch request.
+type httpResult struct {
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Errors   []httpError `json:"errors,omitempty"`
+}
+
+type httpError struct {
+	Item  int    `json:"item"`
+	Error string `json:"error"`
+}
+
+func (r *httpResult) reject(item int, err error) {
+	r.Rejected++
+	r.Errors = append(r.Errors, httpError{Item: item, Error: err.Error()})
+}
+
+// ingestHandler accepts batches of HEP3 packets and varint length prefixed
+// protobuf HEP messages, or JSON objects shaped like decoder.HEP with the
+// content type application/json.
+func (h *HEPInput) ingestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpReply(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if token := config.Get().HEPHTTPToken; token != "" && !validToken(r.Header["Authorization"], token) {
+		httpReply(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	if atomic.LoadUint32(&h.stopped) == 1 {
+		httpReply(w, http.StatusServiceUnavailable, "heplify-server is stopping")
+		return
+	}
+
+	maxBody := int64(config.Get().HEPHTTPMaxBody)
+	if maxBody < 1 {
+		maxBody = 10 << 20
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		// MaxBytesReader has no typed error before Go 1.19
+		if err.Error() == "http: request body too large" {
+			httpReply(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body is larger than %d bytes", maxBody))
+		} else {
+			httpReply(w, http.StatusBadRequest, fmt.Sprintf("can't read body: %v", err))
+		}
+		return
+	}
+
+	in := inputPkt{src: remoteIP(httpRemoteAddr(r.RemoteAddr)), proto: "http"}
+	if r.TLS != nil && config.Get().TLSCertNodeName {
+		in.nodeName = certNodeName(*r.TLS)
+	}
+	res := &httpResult{}
+	switch ct := strings.ToLower(r.Header.Get("Content-Type")); {
+	case strings.HasPrefix(ct, "application/json"), strings.HasPrefix(ct, "application/x-ndjson"):
+		h.ingestJSON(body, in, res)
+	case ct == "", strings.HasPrefix(ct, "application/octet-stream"),
+		strings.HasPrefix(ct, "application/vnd.hep3"), strings.HasPrefix(ct, "application/x-protobuf"):
+		h.ingestBinary(body, in, res)
+	default:
+		httpReply(w, http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type %q", ct))
+		return
+	}
+
+	status := http.StatusOK
+	if res.Accepted == 0 && res.Rejected > 0 {
+		status = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// ingestBinary injects the packets of body with the source and node name of in.
+func (h *HEPInput) ingestBinary(body []byte, in inputPkt, res *httpResult) {
+	f := newFramer(bytes.NewReader(body), &h.stats.ErrCount)
+	for {
+		buf := h.buffer.Get().([]byte)
+		pkt, err := f.next(buf)
+		if err != nil {
+			h.buffer.Put(buf)
+			if err != io.EOF {
+				res.reject(res.Accepted+res.Rejected, fmt.Errorf("truncated packet: %v", err))
+			}
+			break
+		}
+		in.buf = pkt
+		h.inject(in)
+		res.Accepted++
+	}
+	if f.errCount > 0 {
+		res.Rejected += int(f.errCount)
+		res.Errors = append(res.Errors, httpError{Item: -1, Error: fmt.Sprintf("skipped %d invalid packet headers", f.errCount)})
+	}
+}
+
+// ingestJSON reads a JSON array, a single object or newline delimited objects.
+func (h *HEPInput) ingestJSON(body []byte, in inputPkt, res *httpResult) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		if _, err := dec.Token(); err != nil {
+			res.reject(0, err)
+			return
+		}
+	}
+
+	for item := 0; dec.More(); item++ {
+		pkt := &decoder.HEP{}
+		if err := dec.Decode(pkt); err != nil {
+			// the rest of the stream can't be read after a syntax error
+			res.reject(item, err)
+			return
+		}
+		b, err := encodeJSONItem(pkt)
+		if err != nil {
+			res.reject(item, err)
+			continue
+		}
+		buf := h.buffer.Get().([]byte)
+		in.buf = buf[:copy(buf, b)]
+		h.inject(in)
+		res.Accepted++
+	}
+}
+
+// encodeJSONItem encodes a JSON item like decoder.EncodeJSONHEP and checks
+// that it fits into a packet buffer.
+func encodeJSONItem(pkt *decoder.HEP) ([]byte, error) {
+	b, err := decoder.EncodeJSONHEP(pkt)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxPktLen {
+		return nil, fmt.Errorf("packet with %d bytes is too big", len(b))
+	}
+	return b, nil
+}
+
+func (h *HEPInput) inject(in inputPkt) {
+	h.inputCh <- in
+	atomic.AddUint64(&h.stats.PktCount, 1)
+	pktReceived.Inc()
+}
+
+func httpRemoteAddr(addr string) net.Addr {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: net.ParseIP(host)}
+}
+
+func httpReply(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func (h *HEPInput) serveHTTP(addr string) {
+	defer h.listenWg.Done()
+	stop := h.listeners.stopFlag("http", addr)
+
+	if config.Get().HEPHTTPTLS {
+		if err := h.tlsCerts.load(); err != nil {
+			logp.Err("%v", err)
+			h.listeners.set("http", addr, err)
+			return
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logp.Err("%v", err)
+		h.listeners.set("http", addr, err)
+		return
+	}
+	if config.Get().HEPHTTPTLS {
+		ln = tls.NewListener(ln, h.tlsCerts.dynamicConfig("http/1.1"))
+	}
+	h.listeners.set("http", addr, nil)
+	defer h.listeners.set("http", addr, errListenerClosed)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpIngestPath, h.ingestHandler)
+	srv := &http.Server{Handler: mux, ReadTimeout: 30 * time.Second, WriteTimeout: 30 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			logp.Err("%v", err)
+		}
+	}()
+
+	for !h.stopping(stop) {
+		time.Sleep(time.Second)
+	}
+	logp.Info("stopping HTTP listener on %s", ln.Addr())
+	// Shutdown waits for the running requests which may still send to inputCh
+	if err := srv.Shutdown(context.Background()); err != nil {
+		logp.Warn("%v", err)
+	}
+}
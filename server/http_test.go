@@ -0,0 +1,101 @@
+package input
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPIngest(t *testing.T) {
+	config.Setting.HEPHTTPToken = "secret"
+	config.Setting.HEPHTTPMaxBody = 4096
+	defer func() {
+		config.Setting.HEPHTTPToken = ""
+		config.Setting.HEPHTTPMaxBody = 0
+	}()
+
+	h := &HEPInput{
+		inputCh: make(chan inputPkt, 20),
+		buffer:  &sync.Pool{New: func() interface{} { return make([]byte, maxPktLen) }},
+	}
+	post := func(contentType, token string, body []byte) (int, *httpResult) {
+		r := httptest.NewRequest(http.MethodPost, httpIngestPath, bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ingestHandler(w, r)
+		res := &httpResult{}
+		json.NewDecoder(w.Body).Decode(res)
+		return w.Code, res
+	}
+
+	pkt := &decoder.HEP{Version: 2, Protocol: 17, SrcIP: "10.0.0.1", DstIP: "10.0.0.2", ProtoType: 100, Payload: "log line"}
+	hep3, err := decoder.EncodeHEP(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ := post("application/vnd.hep3", "wrong", hep3)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, res := post("application/vnd.hep3", "secret", append(append(append([]byte{}, hep3...), "junk"...), hep3...))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, res.Accepted)
+	assert.Equal(t, 1, res.Rejected)
+
+	code, res = post("application/json", "secret", []byte(`[
+		{"SrcIP":"10.0.0.1","DstIP":"10.0.0.2","ProtoType":1032,"NodeID":5,"Payload":"{\"rtt\":11}"},
+		{"SrcIP":"nope","DstIP":"10.0.0.2","ProtoType":100,"Payload":"x"}
+	]`))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, res.Accepted)
+	assert.Equal(t, 1, res.Rejected)
+	assert.Equal(t, 1, res.Errors[0].Item)
+
+	code, _ = post("application/json", "secret", bytes.Repeat([]byte(" "), 5000))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+
+	code, _ = post("text/plain", "secret", hep3)
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+
+	assert.Equal(t, 3, len(h.inputCh))
+	for i := 0; i < 3; i++ {
+		out, err := decoder.DecodeHEP((<-h.inputCh).buf)
+		assert.NoError(t, err)
+		if i == 2 {
+			assert.Equal(t, uint32(1032), out.ProtoType)
+			assert.Equal(t, uint32(5), out.NodeID)
+		} else {
+			assert.Equal(t, "log line", out.Payload)
+		}
+	}
+
+	config.Setting.TLSCertNodeName = true
+	defer func() { config.Setting.TLSCertNodeName = false }()
+	r := httptest.NewRequest(http.MethodPost, httpIngestPath, bytes.NewReader(hep3))
+	r.Header.Set("Authorization", "Bearer secret")
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "edge-1"}}}}
+	w := httptest.NewRecorder()
+	h.ingestHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "edge-1", (<-h.inputCh).nodeName)
+
+	r = httptest.NewRequest(http.MethodPost, httpIngestPath, iotest.TimeoutReader(bytes.NewReader(hep3)))
+	r.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ingestHandler(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,69 @@
+package input
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pipelinePackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_pipeline_packets_total",
+		Help: "Packets by pipeline result"},
+		[]string{"result"})
+
+	pktReceived = pipelinePackets.WithLabelValues("received")
+	pktDecoded  = pipelinePackets.WithLabelValues("decoded")
+	pktFiltered = pipelinePackets.WithLabelValues("filtered")
+	pktError    = pipelinePackets.WithLabelValues("error")
+
+	decodeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heplify_decode_errors_total",
+		Help: "Packets which could not be decoded by error class"},
+		[]string{"class"})
+
+	deadLetterDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "heplify_deadletter_dropped_total",
+		Help: "Undecodable packets not kept because the dead letter queue was full"})
+
+	queueDepthDesc = prometheus.NewDesc("heplify_queue_depth",
+		"Packets waiting in a pipeline channel", []string{"queue"}, nil)
+	queueCapDesc = prometheus.NewDesc("heplify_queue_capacity",
+		"Capacity of a pipeline channel", []string{"queue"}, nil)
+	udpDropsDesc = prometheus.NewDesc("heplify_udp_kernel_drops_total",
+		"Packets dropped by the kernel on the UDP sockets", []string{"addr"}, nil)
+)
+
+// queueCollector reports the length of the input and output channels
+// and the kernel drops of the UDP sockets at scrape time.
+type queueCollector struct {
+	h *HEPInput
+}
+
+func (c queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- queueCapDesc
+	ch <- udpDropsDesc
+}
+
+func (c queueCollector) Collect(ch chan<- prometheus.Metric) {
+	collect := func(name string, depth, capacity int) {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(depth), name)
+		ch <- prometheus.MustNewConstMetric(queueCapDesc, prometheus.GaugeValue, float64(capacity), name)
+	}
+	collect("input", len(c.h.inputCh), cap(c.h.inputCh))
+	var depth, capacity int
+	for _, shard := range c.h.shards {
+		depth += len(shard)
+		capacity += cap(shard)
+	}
+	collect("workers", depth, capacity)
+	c.h.mu.RLock()
+	sinks := c.h.sinks
+	c.h.mu.RUnlock()
+	for _, s := range sinks {
+		collect(s.name, len(s.ch), cap(s.ch))
+	}
+	for addr, n := range c.h.udpSockets.drops() {
+		ch <- prometheus.MustNewConstMetric(udpDropsDesc, prometheus.CounterValue, float64(n), addr)
+	}
+}
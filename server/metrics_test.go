@@ -0,0 +1,30 @@
+package input
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+func TestQueueCollector(t *testing.T) {
+	h := &HEPInput{
+		inputCh: make(chan inputPkt, 4),
+		sinks:   []*sink{{name: "database", ch: make(chan *decoder.HEP, 2)}},
+	}
+	h.inputCh <- inputPkt{}
+	h.sinks[0].ch <- &decoder.HEP{}
+	h.sinks[0].ch <- &decoder.HEP{}
+
+	want := `
+# HELP heplify_queue_depth Packets waiting in a pipeline channel
+# TYPE heplify_queue_depth gauge
+heplify_queue_depth{queue="database"} 2
+heplify_queue_depth{queue="input"} 1
+heplify_queue_depth{queue="workers"} 0
+`
+	if err := testutil.CollectAndCompare(queueCollector{h}, strings.NewReader(want), "heplify_queue_depth"); err != nil {
+		t.Fatal(err)
+	}
+}
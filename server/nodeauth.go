@@ -0,0 +1,135 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+var nodeAuthRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "heplify_node_auth_rejected_total",
+	Help: "Packets rejected by the node authentication"},
+	[]string{"node", "reason"})
+
+// nodeCred holds the password and the allowed source networks of a node.
+// An empty password or network list is not checked.
+type nodeCred struct {
+	password string
+	nets     []*net.IPNet
+}
+
+// nodeAuth maps a NodeName or NodeID to its credentials.
+type nodeAuth struct {
+	nodes map[string]*nodeCred
+}
+
+// loadNodeAuth reads the NodeAuth entries and the NodeAuthFile. Each entry
+// has the form "node password [cidr...]" where node is the NodeID or NodeName
+// and the password * disables the password check. It returns nil when no
+// entries are configured.
+func loadNodeAuth() (*nodeAuth, error) {
+	entries := append([]string(nil), config.Get().NodeAuth...)
+	if config.Get().NodeAuthFile != "" {
+		b, err := ioutil.ReadFile(config.Get().NodeAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				entries = append(entries, line)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	a := &nodeAuth{nodes: make(map[string]*nodeCred, len(entries))}
+	for _, entry := range entries {
+		f := strings.Fields(entry)
+		if len(f) < 2 {
+			return nil, fmt.Errorf("invalid NodeAuth entry %q, please use \"node password [cidr...]\"", entry)
+		}
+		c := &nodeCred{}
+		if f[1] != "*" {
+			c.password = f[1]
+		}
+		for _, cidr := range f[2:] {
+			if !strings.Contains(cidr, "/") {
+				if strings.Contains(cidr, ":") {
+					cidr += "/128"
+				} else {
+					cidr += "/32"
+				}
+			}
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid NodeAuth entry %q: %v", entry, err)
+			}
+			c.nets = append(c.nets, n)
+		}
+		a.nodes[f[0]] = c
+	}
+	return a, nil
+}
+
+// check returns the configured node of the packet and the reason why it
+// was rejected or an empty string. Packets of nodes which are not
+// configured belong to the node unknown, the packet can't choose a label.
+func (a *nodeAuth) check(h *decoder.HEP, src net.IP) (string, string) {
+	node := h.NodeName
+	c, ok := a.nodes[node]
+	if !ok {
+		node = strconv.FormatUint(uint64(h.NodeID), 10)
+		c, ok = a.nodes[node]
+	}
+	if !ok {
+		return "unknown", "unknown_node"
+	}
+	if c.password != "" && subtle.ConstantTimeCompare([]byte(c.password), []byte(h.NodePW)) != 1 {
+		return node, "password"
+	}
+	if len(c.nets) > 0 {
+		for _, n := range c.nets {
+			if src != nil && n.Contains(src) {
+				return node, ""
+			}
+		}
+		return node, "source"
+	}
+	return node, ""
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// validToken reports whether one of the authorization values is token,
+// with or without Bearer prefix.
+func validToken(authorization []string, token string) bool {
+	for _, v := range authorization {
+		v = strings.TrimPrefix(v, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(v), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
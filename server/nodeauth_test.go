@@ -0,0 +1,43 @@
+package input
+
+import (
+	"net"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeAuth(t *testing.T) {
+	config.Setting.NodeAuth = []string{"2001 secret 10.0.0.0/8 192.168.1.1", "sbc * 2001:db8::/32"}
+	defer func() { config.Setting.NodeAuth = nil }()
+
+	auth, err := loadNodeAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		hep    *decoder.HEP
+		src    string
+		node   string
+		reason string
+	}{
+		{&decoder.HEP{NodeID: 2001, NodeName: "2001", NodePW: "secret"}, "10.1.2.3", "2001", ""},
+		{&decoder.HEP{NodeID: 2001, NodeName: "2001", NodePW: "secret"}, "192.168.1.1", "2001", ""},
+		{&decoder.HEP{NodeID: 2001, NodeName: "2001", NodePW: "wrong"}, "10.1.2.3", "2001", "password"},
+		{&decoder.HEP{NodeID: 2001, NodeName: "2001", NodePW: "secret"}, "192.168.1.2", "2001", "source"},
+		{&decoder.HEP{NodeID: 7, NodeName: "sbc"}, "2001:db8::1", "sbc", ""},
+		{&decoder.HEP{NodeID: 7, NodeName: "7"}, "2001:db8::1", "unknown", "unknown_node"},
+		{&decoder.HEP{NodeID: 12345, NodeName: "random"}, "10.1.2.3", "unknown", "unknown_node"},
+	} {
+		node, reason := auth.check(tc.hep, net.ParseIP(tc.src))
+		assert.Equal(t, tc.reason, reason, "%+v from %s", tc.hep, tc.src)
+		assert.Equal(t, tc.node, node, "%+v from %s", tc.hep, tc.src)
+	}
+
+	config.Setting.NodeAuth = []string{"2001 secret 10.0.0.0/33"}
+	_, err = loadNodeAuth()
+	assert.Error(t, err)
+}
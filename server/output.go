@@ -0,0 +1,361 @@
+package input
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/database"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/sipcapture/heplify-server/metric"
+	"github.com/sipcapture/heplify-server/remotelog"
+)
+
+// Output is a sink which consumes decoded HEP packets from its channel.
+type Output interface {
+	Run(chan *decoder.HEP) error
+	End()
+}
+
+// Spiller is implemented by outputs which can persist packets
+// that don't fit into their channel anymore.
+type Spiller interface {
+	Spill(*decoder.HEP) bool
+}
+
+// Reloader is implemented by outputs which apply some of their
+// settings on SIGHUP without a restart.
+type Reloader interface {
+	Reload()
+}
+
+// OutputConfig holds the queue settings of an output.
+// Policy is the default backpressure policy, see parsePolicy.
+// Hint names the settings to adjust when the channel overflows.
+type OutputConfig struct {
+	Buffer int
+	Filter []int
+	Policy string
+	Hint   string
+}
+
+const (
+	policyDropNewest = "drop_newest"
+	policyDropOldest = "drop_oldest"
+	policyBlock      = "block"
+	policySpill      = "spill"
+)
+
+var outputDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "heplify_output_dropped_total",
+	Help: "Packets dropped because of a full output channel"},
+	[]string{"output"})
+
+// OutputFactory returns a new Output with its queue settings.
+// A nil Output means the sink is disabled.
+type OutputFactory func() (Output, OutputConfig)
+
+var (
+	outputMu       sync.Mutex
+	outputNames    []string
+	outputRegister = map[string]OutputFactory{}
+)
+
+func init() {
+	RegisterOutput("prometheus", newMetricOutput)
+	RegisterOutput("database", newDatabaseOutput)
+	RegisterOutput("elasticsearch", newRemotelogOutput("elasticsearch"))
+	RegisterOutput("loki", newRemotelogOutput("loki"))
+}
+
+// RegisterOutput makes an output available by name. Outputs are
+// fed in the order they have been registered.
+func RegisterOutput(name string, f OutputFactory) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if f == nil {
+		panic("register output " + name + " with nil factory")
+	}
+	if _, dup := outputRegister[name]; !dup {
+		outputNames = append(outputNames, name)
+	}
+	outputRegister[name] = f
+}
+
+// Outputs returns the names of the registered outputs.
+func Outputs() []string {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	return append([]string(nil), outputNames...)
+}
+
+// sink is the runtime side of an enabled output.
+type sink struct {
+	name     string
+	ch       chan *decoder.HEP
+	filter   map[uint32]struct{}
+	out      Output
+	policy   string
+	timeout  time.Duration
+	hint     string
+	lastWarn int64
+	runErr   atomic.Value // string
+}
+
+func newSinks() []*sink {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	var sinks []*sink
+	for _, name := range outputNames {
+		if s := newSink(name, outputRegister[name]); s != nil {
+			sinks = append(sinks, s)
+		}
+	}
+	return sinks
+}
+
+// newSink returns nil when the output is disabled.
+func newSink(name string, f OutputFactory) *sink {
+	out, cfg := f()
+	if out == nil {
+		return nil
+	}
+	if cfg.Buffer < 1 {
+		cfg.Buffer = 1
+	}
+	s := &sink{
+		name: name,
+		ch:   make(chan *decoder.HEP, cfg.Buffer),
+		out:  out,
+		hint: cfg.Hint,
+	}
+	if p, ok := outputPolicy(config.Get().OutputPolicy, name); ok {
+		cfg.Policy = p
+	}
+	var err error
+	if s.policy, s.timeout, err = parsePolicy(cfg.Policy); err != nil {
+		logp.Err("%v, use %s for %s output", err, policyDropNewest, name)
+	}
+	if _, ok := out.(Spiller); s.policy == policySpill && !ok {
+		logp.Err("%s output can't spill, use %s", name, policyDropNewest)
+		s.policy = policyDropNewest
+	}
+	if len(cfg.Filter) > 0 {
+		s.filter = make(map[uint32]struct{}, len(cfg.Filter))
+		for _, v := range cfg.Filter {
+			s.filter[uint32(v)] = struct{}{}
+		}
+	}
+	return s
+}
+
+// spillSettings are the outputs which can spill and the setting enabling it.
+var spillSettings = map[string]string{"database": "DBSpoolDir"}
+
+// CheckOutputPolicies parses the OutputPolicy entries of cfg and rejects
+// spill for outputs which can't spill.
+func CheckOutputPolicies(cfg *config.HeplifyServer) error {
+	var errs config.Errors
+	for _, p := range cfg.OutputPolicy {
+		i := strings.IndexByte(p, '=')
+		policy, _, err := parsePolicy(p[i+1:])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if policy == policySpill {
+			name := p[:i]
+			if enable, ok := spillSettings[name]; !ok {
+				errs = append(errs, fmt.Errorf("invalid output policy %q, the %s output can't spill", p, name))
+			} else if setting(cfg, enable) == "" {
+				errs = append(errs, fmt.Errorf("invalid output policy %q, spill needs %s", p, enable))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// outputPolicy returns the last OutputPolicy entry of the output name.
+func outputPolicy(policies []string, name string) (policy string, ok bool) {
+	for _, p := range policies {
+		if i := strings.IndexByte(p, '='); i > 0 && p[:i] == name {
+			policy, ok = p[i+1:], true
+		}
+	}
+	return policy, ok
+}
+
+func (s *sink) match(hepPkt *decoder.HEP) bool {
+	if s.filter == nil {
+		return true
+	}
+	_, ok := s.filter[hepPkt.ProtoType]
+	return ok
+}
+
+// parsePolicy parses drop_newest, drop_oldest, spill or block:<timeout>.
+// An empty policy is drop_newest. Block needs a timeout, a dead output
+// would otherwise stop the workers and with them End and reload.
+func parsePolicy(policy string) (string, time.Duration, error) {
+	name, arg := policy, ""
+	if i := strings.IndexByte(policy, ':'); i >= 0 {
+		name, arg = policy[:i], policy[i+1:]
+	}
+	switch name {
+	case "":
+		return policyDropNewest, 0, nil
+	case policyDropNewest, policyDropOldest, policySpill:
+		if arg == "" {
+			return name, 0, nil
+		}
+	case policyBlock:
+		if arg == "" {
+			return policyDropNewest, 0, fmt.Errorf("invalid output policy %q, please use block:<timeout> like block:5s", policy)
+		}
+		if d, err := time.ParseDuration(arg); err == nil && d > 0 {
+			return name, d, nil
+		}
+	}
+	return policyDropNewest, 0, fmt.Errorf("invalid output policy %q", policy)
+}
+
+func (s *sink) send(hepPkt *decoder.HEP) {
+	select {
+	case s.ch <- hepPkt:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case policyBlock:
+		t := time.NewTimer(s.timeout)
+		select {
+		case s.ch <- hepPkt:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	case policyDropOldest:
+		select {
+		case <-s.ch:
+			s.drop()
+		default:
+		}
+		select {
+		case s.ch <- hepPkt:
+			return
+		default:
+		}
+	case policySpill:
+		if sp, ok := s.out.(Spiller); ok && sp.Spill(hepPkt) {
+			return
+		}
+	}
+	s.drop()
+}
+
+func (s *sink) drop() {
+	outputDropped.WithLabelValues(s.name).Inc()
+	now := time.Now().UnixNano()
+	if last := atomic.LoadInt64(&s.lastWarn); now-last > 1e9 &&
+		atomic.CompareAndSwapInt64(&s.lastWarn, last, now) {
+		if s.hint != "" {
+			logp.Warn("overflowing %s channel, please adjust %s setting", s.name, s.hint)
+		} else {
+			logp.Warn("overflowing %s channel", s.name)
+		}
+	}
+}
+
+func (s *sink) run() error {
+	if err := s.out.Run(s.ch); err != nil {
+		s.runErr.Store(err.Error())
+		return fmt.Errorf("%s output: %v", s.name, err)
+	}
+	return nil
+}
+
+func newMetricOutput() (Output, OutputConfig) {
+	cfg := OutputConfig{Buffer: config.Get().PromBuffer, Filter: config.Get().PromHEPFilter, Hint: "PromBuffer"}
+	if len(config.Get().PromAddr) <= 2 {
+		return nil, cfg
+	}
+	return &metricOutput{m: metric.New("prometheus")}, cfg
+}
+
+type metricOutput struct{ m *metric.Metric }
+
+func (o *metricOutput) Run(ch chan *decoder.HEP) error {
+	o.m.Chan = ch
+	return o.m.Run()
+}
+
+func (o *metricOutput) End() { o.m.End() }
+
+func (o *metricOutput) Reload() { o.m.Reload() }
+
+func newDatabaseOutput() (Output, OutputConfig) {
+	cfg := OutputConfig{Buffer: config.Get().DBBuffer, Filter: config.Get().DBHEPFilter, Hint: "DBWorker or DBBuffer"}
+	if config.Get().DBSpoolDir != "" {
+		cfg.Policy = policySpill
+	}
+	if len(config.Get().DBAddr) <= 2 {
+		return nil, cfg
+	}
+	return &databaseOutput{d: database.New(config.Get().DBDriver)}, cfg
+}
+
+type databaseOutput struct{ d *database.Database }
+
+func (o *databaseOutput) Run(ch chan *decoder.HEP) error {
+	o.d.Chan = ch
+	return o.d.Run()
+}
+
+func (o *databaseOutput) End() { o.d.End() }
+
+func (o *databaseOutput) Spill(hepPkt *decoder.HEP) bool { return o.d.Spill(hepPkt) }
+
+func (o *databaseOutput) Health() error { return o.d.Health() }
+
+func newRemotelogOutput(name string) OutputFactory {
+	return func() (Output, OutputConfig) {
+		var addr string
+		var cfg OutputConfig
+		switch name {
+		case "elasticsearch":
+			addr = config.Get().ESAddr
+			cfg = OutputConfig{Buffer: config.Get().ESBuffer, Filter: config.Get().ESHEPFilter, Hint: "ESBuffer"}
+		case "loki":
+			addr = config.Get().LokiURL
+			cfg = OutputConfig{Buffer: config.Get().LokiBuffer, Filter: config.Get().LokiHEPFilter, Hint: "LokiBuffer"}
+		}
+		if len(addr) <= 2 {
+			return nil, cfg
+		}
+		return &remotelogOutput{r: remotelog.New(name)}, cfg
+	}
+}
+
+type remotelogOutput struct{ r *remotelog.Remotelog }
+
+func (o *remotelogOutput) Run(ch chan *decoder.HEP) error {
+	o.r.Chan = ch
+	return o.r.Run()
+}
+
+func (o *remotelogOutput) End() { o.r.End() }
+
+func (o *remotelogOutput) Health() error { return o.r.Health() }
+
+func (o *remotelogOutput) LastPush() time.Time { return o.r.LastPush() }
@@ -0,0 +1,84 @@
+package input
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		policy  string
+		timeout time.Duration
+		ok      bool
+	}{
+		{"", policyDropNewest, 0, true},
+		{"drop_oldest", policyDropOldest, 0, true},
+		{"spill", policySpill, 0, true},
+		{"block", policyDropNewest, 0, false},
+		{"block:250ms", policyBlock, 250 * time.Millisecond, true},
+		{"block:-1s", policyDropNewest, 0, false},
+		{"drop_oldest:1s", policyDropNewest, 0, false},
+		{"wait", policyDropNewest, 0, false},
+	} {
+		policy, timeout, err := parsePolicy(tc.in)
+		assert.Equal(t, tc.policy, policy, tc.in)
+		assert.Equal(t, tc.timeout, timeout, tc.in)
+		assert.Equal(t, tc.ok, err == nil, tc.in)
+	}
+}
+
+func TestSinkPolicy(t *testing.T) {
+	first, second := &decoder.HEP{NodeID: 1}, &decoder.HEP{NodeID: 2}
+
+	s := &sink{name: "test", ch: make(chan *decoder.HEP, 1), policy: policyDropNewest}
+	s.send(first)
+	s.send(second)
+	assert.Equal(t, first, <-s.ch)
+
+	s = &sink{name: "test", ch: make(chan *decoder.HEP, 1), policy: policyDropOldest}
+	s.send(first)
+	s.send(second)
+	assert.Equal(t, second, <-s.ch)
+
+	s = &sink{name: "test", ch: make(chan *decoder.HEP, 1), policy: policyBlock, timeout: 10 * time.Millisecond}
+	s.send(first)
+	start := time.Now()
+	s.send(second)
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+	assert.Equal(t, first, <-s.ch)
+
+	s = &sink{name: "test", ch: make(chan *decoder.HEP, 1), policy: policyBlock, timeout: time.Second}
+	s.send(first)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-s.ch
+	}()
+	s.send(second)
+	assert.Equal(t, second, <-s.ch)
+}
+
+func TestCheckOutputPolicies(t *testing.T) {
+	cfg := &config.HeplifyServer{DBSpoolDir: "/var/spool/heplify"}
+	for _, tc := range []struct {
+		policy string
+		ok     bool
+	}{
+		{"database=spill", true},
+		{"database=block:5s", true},
+		{"database=block", false},
+		{"loki=spill", false},
+		{"prometheus=spill", false},
+	} {
+		cfg.OutputPolicy = []string{tc.policy}
+		assert.Equal(t, tc.ok, CheckOutputPolicies(cfg) == nil, tc.policy)
+	}
+
+	cfg.DBSpoolDir = ""
+	cfg.OutputPolicy = []string{"database=spill"}
+	assert.Error(t, CheckOutputPolicies(cfg))
+}
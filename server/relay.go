@@ -0,0 +1,228 @@
+package input
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+func init() {
+	RegisterOutput("forward", newRelayOutput)
+}
+
+// relay forwards the received HEP packets to downstream HEP collectors.
+type relay struct {
+	ch      chan *decoder.HEP
+	targets []*relayTarget
+	wg      sync.WaitGroup
+}
+
+// relayTarget is a single downstream collector like
+// udp://10.0.0.1:9060?type=1&type=5&node=2001
+type relayTarget struct {
+	network  string
+	addr     string
+	insecure bool
+	types    map[uint32]struct{}
+	nodes    map[uint32]struct{}
+	ch       chan []byte
+	conn     net.Conn
+	lastDial time.Time
+	lastWarn time.Time
+}
+
+func newRelayOutput() (Output, OutputConfig) {
+	cfg := OutputConfig{Buffer: config.Get().ForwardBuffer, Hint: "ForwardBuffer"}
+	if len(config.Get().ForwardAddr) == 0 {
+		return nil, cfg
+	}
+	return &relay{}, cfg
+}
+
+func (r *relay) Run(ch chan *decoder.HEP) error {
+	r.ch = ch
+	for _, addr := range config.Get().ForwardAddr {
+		t, err := parseRelayTarget(addr)
+		if err != nil {
+			return err
+		}
+		r.targets = append(r.targets, t)
+	}
+
+	for _, t := range r.targets {
+		logp.Info("forward HEP packets to %s://%s", t.network, t.addr)
+		r.wg.Add(1)
+		go func(t *relayTarget) {
+			defer r.wg.Done()
+			t.run()
+		}(t)
+	}
+
+	go func() {
+		for pkt := range ch {
+			raw := pkt.Raw
+			if config.Get().ForwardEncode {
+				// forward the packet as it looks after script processing
+				var err error
+				if raw, err = decoder.EncodeHEP(pkt); err != nil {
+					logp.Warn("%v", err)
+					continue
+				}
+			}
+			if raw == nil {
+				continue
+			}
+			for _, t := range r.targets {
+				if t.match(pkt) {
+					t.send(raw)
+				}
+			}
+		}
+		for _, t := range r.targets {
+			close(t.ch)
+		}
+	}()
+	return nil
+}
+
+func (r *relay) End() {
+	close(r.ch)
+	r.wg.Wait()
+	logp.Info("close forward channel")
+}
+
+func parseRelayTarget(addr string) (*relayTarget, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ForwardAddr %q: %v", addr, err)
+	}
+	t := &relayTarget{
+		network: strings.ToLower(u.Scheme),
+		addr:    u.Host,
+		ch:      make(chan []byte, config.Get().ForwardBuffer),
+	}
+	switch t.network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("invalid ForwardAddr %q, please use udp://, tcp:// or tls://", addr)
+	}
+	if _, _, err := net.SplitHostPort(t.addr); err != nil {
+		return nil, fmt.Errorf("invalid ForwardAddr %q: %v", addr, err)
+	}
+
+	q := u.Query()
+	if t.types, err = parseUintSet(q["type"]); err != nil {
+		return nil, fmt.Errorf("invalid type filter in ForwardAddr %q: %v", addr, err)
+	}
+	if t.nodes, err = parseUintSet(q["node"]); err != nil {
+		return nil, fmt.Errorf("invalid node filter in ForwardAddr %q: %v", addr, err)
+	}
+	t.insecure = q.Get("insecure") == "true"
+	return t, nil
+}
+
+func parseUintSet(values []string) (map[uint32]struct{}, error) {
+	var set map[uint32]struct{}
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			i, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			if set == nil {
+				set = make(map[uint32]struct{})
+			}
+			set[uint32(i)] = struct{}{}
+		}
+	}
+	return set, nil
+}
+
+func (t *relayTarget) match(pkt *decoder.HEP) bool {
+	if t.types != nil {
+		if _, ok := t.types[pkt.ProtoType]; !ok {
+			return false
+		}
+	}
+	if t.nodes != nil {
+		if _, ok := t.nodes[pkt.NodeID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *relayTarget) send(b []byte) {
+	select {
+	case t.ch <- b:
+	default:
+		t.warn("overflowing forward channel")
+	}
+}
+
+func (t *relayTarget) warn(msg string) {
+	if time.Since(t.lastWarn) > 1e9 {
+		logp.Warn("%s to %s://%s", msg, t.network, t.addr)
+		t.lastWarn = time.Now()
+	}
+}
+
+func (t *relayTarget) run() {
+	defer func() {
+		if t.conn != nil {
+			t.conn.Close()
+		}
+	}()
+
+	for b := range t.ch {
+		if err := t.write(b); err != nil {
+			t.close()
+			// retry once with a fresh connection
+			if err = t.write(b); err != nil {
+				t.close()
+				t.warn(fmt.Sprintf("%v, drop packet", err))
+			}
+		}
+	}
+}
+
+func (t *relayTarget) write(b []byte) error {
+	if t.conn == nil {
+		if time.Since(t.lastDial) < time.Second {
+			return fmt.Errorf("waiting for reconnect")
+		}
+		t.lastDial = time.Now()
+		conn, err := t.dial()
+		if err != nil {
+			return err
+		}
+		t.conn = conn
+	}
+	t.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := t.conn.Write(b)
+	return err
+}
+
+func (t *relayTarget) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	if t.network == "tls" {
+		return tls.DialWithDialer(d, "tcp", t.addr, &tls.Config{InsecureSkipVerify: t.insecure})
+	}
+	return d.Dial(t.network, t.addr)
+}
+
+func (t *relayTarget) close() {
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+}
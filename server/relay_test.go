@@ -0,0 +1,33 @@
+package input
+
+import (
+	"testing"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRelayTarget(t *testing.T) {
+	rt, err := parseRelayTarget("tls://10.0.0.1:9061?type=1,5&type=100&node=2001&insecure=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "tls", rt.network)
+	assert.Equal(t, "10.0.0.1:9061", rt.addr)
+	assert.True(t, rt.insecure)
+	assert.Len(t, rt.types, 3)
+	assert.True(t, rt.match(&decoder.HEP{ProtoType: 5, NodeID: 2001}))
+	assert.False(t, rt.match(&decoder.HEP{ProtoType: 5, NodeID: 2002}))
+	assert.False(t, rt.match(&decoder.HEP{ProtoType: 53, NodeID: 2001}))
+
+	rt, err = parseRelayTarget("udp://10.0.0.1:9060")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, rt.match(&decoder.HEP{ProtoType: 53, NodeID: 1}))
+
+	for _, addr := range []string{"http://10.0.0.1:9060", "udp://10.0.0.1", "tcp://10.0.0.1:9060?type=sip"} {
+		_, err = parseRelayTarget(addr)
+		assert.Error(t, err, addr)
+	}
+}
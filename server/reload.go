@@ -0,0 +1,352 @@
+package input
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/rotator"
+)
+
+var errStarting = errors.New("heplify-server is still starting")
+
+// ErrStopping is returned by Inject and reload after End was called.
+var ErrStopping = errors.New("heplify-server is stopping")
+
+// listenerSpecs are the HEP listeners with the setting holding their
+// addresses and the settings which restart all listeners of the protocol.
+var listenerSpecs = []struct {
+	proto    string
+	addrs    string
+	settings []string
+}{
+	{"udp", "HEPAddr", []string{"HEPUDPSockets", "HEPUDPReadBuffer"}},
+	{"ws", "HEPWSAddr", nil},
+	{"tcp", "HEPTCPAddr", nil},
+	{"tls", "HEPTLSAddr", nil},
+	{"unix", "HEPUnixAddr", nil},
+	{"unixgram", "HEPUnixgramAddr", nil},
+	{"grpc", "HEPGRPCAddr", []string{"HEPGRPCTLS"}},
+	{"http", "HEPHTTPAddr", []string{"HEPHTTPTLS"}},
+}
+
+// outputSettings are the settings which restart an output on SIGHUP.
+// A changed OutputPolicy entry restarts the output too.
+var outputSettings = map[string][]string{
+	"prometheus":    {"PromBuffer", "PromHEPFilter"},
+	"database":      {"DBShema", "DBDriver", "DBAddr", "DBUser", "DBPass", "DBDataTable", "DBConfTable", "DBBulk", "DBTimer", "DBBuffer", "DBWorker", "DBHEPFilter", "DBSpoolDir", "DBSpoolMaxSize", "ForceHEPPayload", "SIPHeader"},
+	"elasticsearch": {"ESAddr", "ESDiscovery", "ESUser", "ESPass", "ESBuffer", "ESHEPFilter"},
+	"loki":          {"LokiURL", "LokiBulk", "LokiTimer", "LokiBuffer", "LokiHEPFilter"},
+	"forward":       {"ForwardAddr", "ForwardBuffer", "ForwardEncode"},
+}
+
+// rotatorSettings restart the rotator with its new schedule.
+var rotatorSettings = []string{"DBDriver", "DBAddr", "DBUser", "DBPass", "DBDataTable", "DBConfTable", "DBRotate",
+	"DBPartLog", "DBPartIsup", "DBPartSip", "DBPartQos", "DBDropDays", "DBDropDaysCall", "DBDropDaysRegister",
+	"DBDropDaysDefault", "DBDropOnStart"}
+
+// restartSettings can only be changed by a restart. They keep their
+// old value on SIGHUP.
+var restartSettings = []string{"WorkerShards", "WorkerBuffer", "PromAddr", "ConfigHTTPAddr", "HealthHTTPAddr",
+	"LogDbg", "LogLvl", "LogStd", "LogSys", "Config", "Version"}
+
+// listenerCtl stops a single listener and the connections it accepted.
+type listenerCtl struct {
+	stop uint32
+	done chan struct{}
+}
+
+// stopFlag returns the flag which stops the listener on addr.
+func (l *listeners) stopFlag(proto, addr string) *uint32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ctl == nil {
+		l.ctl = make(map[string]*listenerCtl)
+	}
+	c, ok := l.ctl[proto+" "+addr]
+	if !ok {
+		c = &listenerCtl{}
+		l.ctl[proto+" "+addr] = c
+	}
+	return &c.stop
+}
+
+// stopping reports whether the server or the listener of stop is stopping.
+func (h *HEPInput) stopping(stop *uint32) bool {
+	return atomic.LoadUint32(&h.stopped) == 1 || atomic.LoadUint32(stop) == 1
+}
+
+func (h *HEPInput) serveFunc(proto string) func(string) {
+	switch proto {
+	case "udp":
+		return h.serveUDP
+	case "ws":
+		return h.serveWS
+	case "tcp":
+		return h.serveTCP
+	case "tls":
+		return h.serveTLS
+	case "unix":
+		return h.serveUnix
+	case "unixgram":
+		return h.serveUnixgram
+	case "grpc":
+		return h.serveGRPC
+	case "http":
+		return h.serveHTTP
+	}
+	return nil
+}
+
+func (h *HEPInput) startListener(proto, addr string) {
+	c := &listenerCtl{done: make(chan struct{})}
+	h.listeners.mu.Lock()
+	if h.listeners.ctl == nil {
+		h.listeners.ctl = make(map[string]*listenerCtl)
+	}
+	h.listeners.ctl[proto+" "+addr] = c
+	h.listeners.mu.Unlock()
+
+	h.listeners.set(proto, addr, errListenerStarting)
+	h.listenWg.Add(1)
+	serve := h.serveFunc(proto)
+	go func() {
+		serve(addr)
+		close(c.done)
+	}()
+}
+
+// stopListener waits until the listener on addr and its connections are
+// closed and forgets its state.
+func (h *HEPInput) stopListener(proto, addr string) {
+	key := proto + " " + addr
+	h.listeners.mu.Lock()
+	c := h.listeners.ctl[key]
+	delete(h.listeners.ctl, key)
+	h.listeners.mu.Unlock()
+	if c != nil {
+		atomic.StoreUint32(&c.stop, 1)
+		<-c.done
+	}
+
+	h.listeners.mu.Lock()
+	delete(h.listeners.state, key)
+	h.listeners.mu.Unlock()
+}
+
+// reloadListeners starts, stops and restarts the listeners whose
+// settings differ from old.
+func (h *HEPInput) reloadListeners(old *config.HeplifyServer, changed map[string]bool) {
+	for _, l := range listenerSpecs {
+		oldAddrs := listenAddrs(setting(old, l.addrs))
+		newAddrs := listenAddrs(setting(config.Get(), l.addrs))
+		restart := false
+		for _, name := range l.settings {
+			restart = restart || changed[name]
+		}
+		for _, addr := range oldAddrs {
+			if restart || !contains(newAddrs, addr) {
+				logp.Info("stop %s listener on %s", l.proto, addr)
+				h.stopListener(l.proto, addr)
+			}
+		}
+		for _, addr := range newAddrs {
+			if restart || !contains(oldAddrs, addr) {
+				logp.Info("start %s listener on %s", l.proto, addr)
+				h.startListener(l.proto, addr)
+			}
+		}
+	}
+}
+
+// reloadOutputs restarts the outputs with changed settings. The workers
+// must be stopped, an old output still writes the packets of its channel.
+func (h *HEPInput) reloadOutputs(old *config.HeplifyServer, changed map[string]bool) []string {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	var restarted []string
+	var sinks []*sink
+	for _, name := range outputNames {
+		cur := h.findSink(name)
+		restart := cur == nil
+		for _, s := range outputSettings[name] {
+			restart = restart || changed[s]
+		}
+		oldPolicy, _ := outputPolicy(old.OutputPolicy, name)
+		newPolicy, _ := outputPolicy(config.Get().OutputPolicy, name)
+		restart = restart || oldPolicy != newPolicy
+		if !restart {
+			if r, ok := cur.out.(Reloader); ok {
+				r.Reload()
+			}
+			sinks = append(sinks, cur)
+			continue
+		}
+
+		if cur != nil {
+			logp.Info("stop %s output", name)
+			cur.out.End()
+			restarted = append(restarted, name)
+		}
+		s := newSink(name, outputRegister[name])
+		if s == nil {
+			continue
+		}
+		if cur == nil {
+			restarted = append(restarted, name)
+		}
+		logp.Info("start %s output", name)
+		if err := s.run(); err != nil {
+			logp.Err("%v", err)
+		}
+		sinks = append(sinks, s)
+	}
+
+	h.mu.Lock()
+	h.sinks = sinks
+	h.mu.Unlock()
+	return restarted
+}
+
+// reloadRotator replaces the rotator when its schedule or the database changed.
+func (h *HEPInput) reloadRotator(changed map[string]bool) {
+	restart := false
+	for _, name := range rotatorSettings {
+		restart = restart || changed[name]
+	}
+	if !restart {
+		return
+	}
+
+	h.mu.Lock()
+	old := h.rotator
+	h.rotator = nil
+	h.mu.Unlock()
+	if old != nil {
+		old.End()
+	}
+	if h.findSink("database") == nil || !config.Get().DBRotate ||
+		(config.Get().DBDriver != "mysql" && config.Get().DBDriver != "postgres") {
+		return
+	}
+
+	r := rotator.Setup(h.quit)
+	h.mu.Lock()
+	h.rotator = r
+	h.mu.Unlock()
+	// creating the tables waits for the database
+	go r.Rotate()
+}
+
+// reload reads the configuration file again and applies it. Packets
+// which are already queued stay in the shards while the workers restart.
+// It returns the applied settings and the settings which need a restart.
+func (h *HEPInput) reload() ([]string, []string, error) {
+	cfg, err := config.Load(config.Get().Config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := CheckOutputPolicies(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	if atomic.LoadUint32(&h.stopped) == 1 {
+		return nil, nil, ErrStopping
+	}
+	select {
+	case <-h.ready:
+	default:
+		return nil, nil, errStarting
+	}
+
+	h.wg.Add(1)
+	defer h.wg.Done()
+	h.stopWorker()
+
+	old := *config.Get()
+	cfg.AlegIDs = config.GenerateRegexMap(cfg.AlegIDs)
+	changed := make(map[string]bool)
+	var names, restart []string
+	for _, name := range config.Changed(&old, cfg) {
+		if contains(restartSettings, name) {
+			setSetting(cfg, &old, name)
+			restart = append(restart, name)
+			continue
+		}
+		changed[name] = true
+		names = append(names, name)
+	}
+	config.Set(cfg)
+	sort.Strings(names)
+	if len(names) > 0 {
+		logp.Info("reload configuration, changed settings: %s", strings.Join(names, ", "))
+	} else {
+		logp.Info("reload configuration without changed settings")
+	}
+
+	restarted := h.reloadOutputs(&old, changed)
+	h.reloadRotator(changed)
+	if changed["DeadLetterDir"] || changed["DeadLetterMaxSize"] {
+		var d *deadLetterDir
+		if cfg.DeadLetterDir != "" {
+			if d, err = newDeadLetterDir(cfg.DeadLetterDir, int64(cfg.DeadLetterMaxSize)<<20); err != nil {
+				logp.Err("%v", err)
+			}
+		}
+		h.mu.Lock()
+		old := h.deadLetter
+		h.deadLetter = d
+		h.mu.Unlock()
+		if old != nil {
+			old.close()
+		}
+	}
+	if len(cfg.HEPTLSAddr) > 2 || cfg.HEPGRPCTLS || cfg.HEPHTTPTLS {
+		if err := h.tlsCerts.load(); err != nil {
+			logp.Err("keep old TLS certificates: %v", err)
+		}
+	}
+	if auth, err := loadNodeAuth(); err != nil {
+		logp.Err("keep old node authentication: %v", err)
+	} else {
+		h.nodeAuth.Store(auth)
+	}
+	h.startWorkers()
+
+	// the workers have to run, a stopping listener may wait on a full queue
+	h.reloadListeners(&old, changed)
+
+	h.pending.Store(restart)
+	if len(restarted) > 0 {
+		logp.Info("restarted outputs: %s", strings.Join(restarted, ", "))
+	}
+	if len(restart) > 0 {
+		logp.Warn("settings %s need a restart of heplify-server", strings.Join(restart, ", "))
+	}
+	return names, restart, nil
+}
+
+func setting(cfg *config.HeplifyServer, name string) string {
+	return reflect.ValueOf(cfg).Elem().FieldByName(name).String()
+}
+
+// setSetting copies the setting name from src to dst.
+func setSetting(dst, src *config.HeplifyServer, name string) {
+	reflect.ValueOf(dst).Elem().FieldByName(name).Set(reflect.ValueOf(src).Elem().FieldByName(name))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
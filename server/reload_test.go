@@ -0,0 +1,95 @@
+package input
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config.Setting
+	defer func() { config.Setting = saved }()
+
+	h := &HEPInput{
+		inputCh: make(chan inputPkt, 10),
+		buffer:  &sync.Pool{New: func() interface{} { return make([]byte, maxPktLen) }},
+	}
+	oldAddr, newAddr := filepath.Join(dir, "old.sock"), filepath.Join(dir, "new.sock")
+	config.Setting.HEPUnixAddr = oldAddr
+	h.startListener("unix", oldAddr)
+	var conn net.Conn
+	for i := 0; i < 100 && conn == nil; i++ {
+		conn, _ = net.Dial("unix", oldAddr)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conn == nil {
+		t.Fatal("dial old listener failed")
+	}
+	defer conn.Close()
+
+	// the idle connection of the removed listener is closed
+	old := *config.Get()
+	cfg := old
+	cfg.HEPUnixAddr = newAddr
+	config.Set(&cfg)
+	defer config.Set(nil)
+	h.reloadListeners(&old, map[string]bool{})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	if ne, ok := err.(net.Error); ok {
+		assert.False(t, ne.Timeout())
+	}
+
+	var state []*listenerState
+	for i := 0; i < 100; i++ {
+		if state = h.listeners.list(); len(state) == 1 && state[0].Bound {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if assert.Len(t, state, 1) {
+		assert.Equal(t, newAddr, state[0].Addr)
+		assert.True(t, state[0].Bound)
+	}
+
+	atomic.StoreUint32(&h.stopped, 1)
+	h.listenWg.Wait()
+}
+
+func TestReloadOutputs(t *testing.T) {
+	saved := config.Setting
+	defer func() { config.Setting = saved }()
+	config.Setting.DBAddr, config.Setting.PromAddr = "", ""
+
+	h := &HEPInput{sinks: newSinks()}
+	first := h.findSink("test")
+	if first == nil {
+		t.Fatal("test output is not enabled")
+	}
+
+	old := config.Setting
+	assert.Empty(t, h.reloadOutputs(&old, map[string]bool{"DBAddr": true}))
+	assert.True(t, first == h.findSink("test"))
+
+	cfg := old
+	cfg.OutputPolicy = []string{"test=block:1s"}
+	config.Set(&cfg)
+	defer config.Set(nil)
+	assert.Equal(t, []string{"test"}, h.reloadOutputs(&old, map[string]bool{"OutputPolicy": true}))
+	assert.False(t, first == h.findSink("test"))
+	assert.Equal(t, policyBlock, h.findSink("test").policy)
+}
@@ -1,43 +1,45 @@
 package input
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
-	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/negbie/logp"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sipcapture/heplify-server/config"
-	"github.com/sipcapture/heplify-server/database"
 	"github.com/sipcapture/heplify-server/decoder"
-	"github.com/sipcapture/heplify-server/metric"
-	"github.com/sipcapture/heplify-server/remotelog"
 	"github.com/sipcapture/heplify-server/rotator"
 )
 
 type HEPInput struct {
-	inputCh    chan []byte
-	dbCh       chan *decoder.HEP
-	promCh     chan *decoder.HEP
-	esCh       chan *decoder.HEP
-	lokiCh     chan *decoder.HEP
+	inputCh    chan inputPkt
+	sinks      []*sink
+	tlsCerts   *tlsCerts
+	nodeAuth   atomic.Value // *nodeAuth
+	listeners  listeners
+	udpSockets udpSockets
+	rotator    *rotator.Rotator
+	deadLetter *deadLetterDir
+	scriptErr  atomic.Value // string error of the script engine
+	mu         sync.RWMutex // guards sinks, rotator and deadLetter on reload
+	reloadMu   sync.Mutex
+	pending    atomic.Value // []string settings which need a restart
 	wg         *sync.WaitGroup
 	buffer     *sync.Pool
-	exitUDP    chan bool
-	exitTCP    chan bool
-	exitTLS    chan bool
-	exitWS     chan bool
-	exitWorker chan bool
+	listenWg   sync.WaitGroup
+	shards     []chan inputPkt
+	stopWorker func()
 	quit       chan bool
+	ready      chan struct{}
 	stopped    uint32
 	stats      HEPStats
-	useDB      bool
-	usePM      bool
-	useES      bool
-	useLK      bool
 }
 
 type HEPStats struct {
@@ -47,156 +49,154 @@ type HEPStats struct {
 	PktCount uint64
 }
 
+// inputPkt is a received packet with the details of its connection
+type inputPkt struct {
+	buf      []byte
+	src      net.IP
+	proto    string
+	nodeName string
+}
+
 const maxPktLen = 65507
 
 func NewHEPInput() *HEPInput {
 	h := &HEPInput{
-		inputCh:    make(chan []byte, 40000),
-		buffer:     &sync.Pool{New: func() interface{} { return make([]byte, maxPktLen) }},
-		wg:         &sync.WaitGroup{},
-		quit:       make(chan bool),
-		exitUDP:    make(chan bool),
-		exitTCP:    make(chan bool),
-		exitTLS:    make(chan bool),
-		exitWS:     make(chan bool),
-		exitWorker: make(chan bool),
-	}
-	if len(config.Setting.DBAddr) > 2 {
-		h.useDB = true
-		h.dbCh = make(chan *decoder.HEP, config.Setting.DBBuffer)
+		inputCh:  make(chan inputPkt, 40000),
+		shards:   newShards(),
+		buffer:   &sync.Pool{New: func() interface{} { return make([]byte, maxPktLen) }},
+		wg:       &sync.WaitGroup{},
+		quit:     make(chan bool),
+		ready:    make(chan struct{}),
+		sinks:    newSinks(),
+		tlsCerts: &tlsCerts{},
 	}
-	if len(config.Setting.PromAddr) > 2 {
-		h.usePM = true
-		h.promCh = make(chan *decoder.HEP, 40000)
-	}
-	if len(config.Setting.ESAddr) > 2 {
-		h.useES = true
-		h.esCh = make(chan *decoder.HEP, 40000)
+
+	auth, err := loadNodeAuth()
+	if err != nil {
+		// reject all packets instead of running without authentication
+		logp.Err("%v", err)
+		auth = &nodeAuth{}
 	}
-	if len(config.Setting.LokiURL) > 2 {
-		h.useLK = true
-		h.lokiCh = make(chan *decoder.HEP, config.Setting.LokiBuffer)
+	h.nodeAuth.Store(auth)
+
+	if config.Get().DeadLetterDir != "" {
+		h.deadLetter, err = newDeadLetterDir(config.Get().DeadLetterDir, int64(config.Get().DeadLetterMaxSize)<<20)
+		if err != nil {
+			logp.Err("%v", err)
+		}
 	}
 
 	return h
 }
 
 func (h *HEPInput) Run() {
+	cfg := config.Get()
 
-	for n := 0; n < runtime.NumCPU(); n++ {
-		h.wg.Add(1)
-		go h.worker()
-	}
+	go h.dispatch()
+	h.startWorkers()
 
-	s := config.Setting
-	s.DBPass = "<private>"
-	logp.Info("start %s with %#v\n", config.Version, s)
+	logp.Info("start %s with %#v\n", config.Version, cfg.Masked())
 	go h.logStats()
-	go h.reloadWorker()
-
-	if len(config.Setting.HEPAddr) > 2 {
-		go h.serveUDP(config.Setting.HEPAddr)
-	}
-	if len(config.Setting.HEPWSAddr) > 2 {
-		go h.serveWS(config.Setting.HEPWSAddr)
-	}
-	if len(config.Setting.HEPTCPAddr) > 2 {
-		go h.serveTCP(config.Setting.HEPTCPAddr)
+	if err := prometheus.Register(queueCollector{h}); err != nil {
+		logp.Warn("queue metrics: %v", err)
+	} else {
+		defer prometheus.Unregister(queueCollector{h})
 	}
-	if len(config.Setting.HEPTLSAddr) > 2 {
-		go h.serveTLS(config.Setting.HEPTLSAddr)
-	}
-
-	if h.usePM {
-		m := metric.New("prometheus")
-		m.Chan = h.promCh
+	go h.reloadWorker()
 
-		if err := m.Run(); err != nil {
-			logp.Err("%v", err)
+	for _, l := range listenerSpecs {
+		for _, addr := range listenAddrs(setting(cfg, l.addrs)) {
+			h.startListener(l.proto, addr)
 		}
-		defer m.End()
 	}
 
-	if h.useES {
-		r := remotelog.New("elasticsearch")
-		r.Chan = h.esCh
-
-		if err := r.Run(); err != nil {
-			logp.Err("%v", err)
+	for _, s := range h.sinks {
+		if s.name == "database" && cfg.DBRotate &&
+			(cfg.DBDriver == "mysql" || cfg.DBDriver == "postgres") {
+			h.rotator = rotator.Setup(h.quit)
+			h.rotator.Rotate()
 		}
-		defer r.End()
-	}
 
-	if h.useLK {
-		l := remotelog.New("loki")
-		l.Chan = h.lokiCh
-
-		if err := l.Run(); err != nil {
+		if err := s.run(); err != nil {
 			logp.Err("%v", err)
 		}
-		defer l.End()
 	}
-
-	if h.useDB && config.Setting.DBRotate &&
-		(config.Setting.DBDriver == "mysql" || config.Setting.DBDriver == "postgres") {
-		r := rotator.Setup(h.quit)
-		r.Rotate()
-		defer r.End()
-	}
-
-	if h.useDB {
-		d := database.New(config.Setting.DBDriver)
-		d.Chan = h.dbCh
-
-		if err := d.Run(); err != nil {
-			logp.Err("%v", err)
+	// a reload may have replaced the outputs and the rotator
+	defer func() {
+		h.mu.RLock()
+		sinks, rot := h.sinks, h.rotator
+		h.mu.RUnlock()
+		for i := len(sinks) - 1; i >= 0; i-- {
+			sinks[i].out.End()
+			if sinks[i].name == "database" && rot != nil {
+				rot.End()
+			}
 		}
-		defer d.End()
-	}
+	}()
 
+	activeInput.Store(h)
+	close(h.ready)
 	h.wg.Wait()
 }
 
-func (h *HEPInput) End() {
-	atomic.StoreUint32(&h.stopped, 1)
+// Ready is closed when Run has started the listeners and outputs.
+func (h *HEPInput) Ready() <-chan struct{} {
+	return h.ready
+}
 
-	if len(config.Setting.HEPAddr) > 2 {
-		<-h.exitUDP
+// Inject feeds a HEP packet into the pipeline like a received one.
+// It blocks while the input queue is full and must not be called after End.
+func (h *HEPInput) Inject(packet []byte) error {
+	if len(packet) > maxPktLen {
+		return fmt.Errorf("packet with %d bytes is too big", len(packet))
 	}
-	if len(config.Setting.HEPTCPAddr) > 2 {
-		<-h.exitTCP
+	if atomic.LoadUint32(&h.stopped) == 1 {
+		return ErrStopping
 	}
-	if len(config.Setting.HEPWSAddr) > 2 {
-		<-h.exitWS
-	}
-	if len(config.Setting.HEPTLSAddr) > 2 {
-		<-h.exitTLS
+	buf := h.buffer.Get().([]byte)
+	h.inject(inputPkt{buf: buf[:copy(buf, packet)], proto: "inject"})
+	return nil
+}
+
+func (h *HEPInput) End() {
+	// a running reload finishes before the listeners stop
+	h.reloadMu.Lock()
+	atomic.StoreUint32(&h.stopped, 1)
+	h.reloadMu.Unlock()
+	if a, _ := activeInput.Load().(*HEPInput); a == h {
+		activeInput.Store((*HEPInput)(nil))
 	}
 
-	h.exitWorker <- true
-	<-h.exitWorker
+	h.listenWg.Wait()
+
+	// the workers drain the queued packets before they stop
+	close(h.inputCh)
+	h.wg.Wait()
+	if h.deadLetter != nil {
+		h.deadLetter.close()
+	}
 
 	h.quit <- true
 	<-h.quit
-	close(h.inputCh)
 }
 
-func (h *HEPInput) worker() {
-	defer h.wg.Done()
-
+func (h *HEPInput) worker(shard chan inputPkt, exit chan struct{}) {
 	var ok bool
+	var in inputPkt
 	var err error
 	var script decoder.ScriptEngine
-	lastWarn := time.Now()
 	msg := h.buffer.Get().([]byte)
-	useScript := config.Setting.ScriptEnable
+	useScript := config.Get().ScriptEnable
+	keepRaw := h.findSink("forward") != nil && !config.Get().ForwardEncode
 
 	if useScript {
 		script, err = decoder.NewScriptEngine()
 		if err != nil {
 			logp.Err("%v, please fix and run killall -HUP heplify-server", err)
+			h.scriptErr.Store(err.Error())
 			useScript = false
 		} else {
+			h.scriptErr.Store("")
 			defer script.Close()
 		}
 	}
@@ -204,25 +204,49 @@ func (h *HEPInput) worker() {
 	for {
 		h.buffer.Put(msg[:maxPktLen])
 		select {
-		case <-h.exitWorker:
-			h.exitWorker <- true
+		case <-exit:
 			return
-		case msg, ok = <-h.inputCh:
+		case in, ok = <-shard:
 			if !ok {
 				return
 			}
+			msg = in.buf
 			hepPkt, err := decoder.DecodeHEP(msg)
 			if err != nil {
 				atomic.AddUint64(&h.stats.ErrCount, 1)
+				pktError.Inc()
+				decodeErrors.WithLabelValues(decoder.ErrorClass(err)).Inc()
+				h.mu.RLock()
+				if h.deadLetter != nil && !h.deadLetter.add(in, err) {
+					deadLetterDropped.Inc()
+				}
+				h.mu.RUnlock()
 				continue
 			} else if hepPkt.ProtoType == 0 {
 				atomic.AddUint64(&h.stats.DupCount, 1)
+				pktFiltered.Inc()
 				continue
 			}
+			// the name of the client certificate wins over the one of the agent
+			if in.nodeName != "" {
+				hepPkt.NodeName = in.nodeName
+			}
+			if auth, _ := h.nodeAuth.Load().(*nodeAuth); auth != nil {
+				if node, reason := auth.check(hepPkt, in.src); reason != "" {
+					nodeAuthRejected.WithLabelValues(node, reason).Inc()
+					logp.Debug("auth", "reject packet from nodeID %d, source %s: %s", hepPkt.NodeID, in.src, reason)
+					continue
+				}
+			}
 			atomic.AddUint64(&h.stats.HEPCount, 1)
+			pktDecoded.Inc()
+
+			if keepRaw {
+				hepPkt.Raw = append([]byte(nil), msg...)
+			}
 
 			if useScript {
-				for _, v := range config.Setting.ScriptHEPFilter {
+				for _, v := range config.Get().ScriptHEPFilter {
 					if hepPkt.ProtoType == uint32(v) {
 						if err = script.Run(hepPkt); err != nil {
 							logp.Err("%v", err)
@@ -236,56 +260,33 @@ func (h *HEPInput) worker() {
 				}
 			}
 
-			if h.usePM {
-				select {
-				case h.promCh <- hepPkt:
-				default:
-					if time.Since(lastWarn) > 1e9 {
-						logp.Warn("overflowing metric channel")
-					}
-					lastWarn = time.Now()
-				}
-			}
-
-			if h.useDB {
-				select {
-				case h.dbCh <- hepPkt:
-				default:
-					if time.Since(lastWarn) > 1e9 {
-						logp.Warn("overflowing db channel, please adjust DBWorker or DBBuffer setting")
-					}
-					lastWarn = time.Now()
+			for _, s := range h.sinks {
+				if s.match(hepPkt) {
+					s.send(hepPkt)
 				}
 			}
+		}
+	}
+}
 
-			if h.useES {
-				select {
-				case h.esCh <- hepPkt:
-				default:
-					if time.Since(lastWarn) > 1e9 {
-						logp.Warn("overflowing elasticsearch channel")
-					}
-					lastWarn = time.Now()
-				}
-			}
+// listenAddrs splits a comma separated list of listen addresses.
+func listenAddrs(addrs string) []string {
+	var list []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 2 {
+			list = append(list, addr)
+		}
+	}
+	return list
+}
 
-			if h.useLK {
-				for _, v := range config.Setting.LokiHEPFilter {
-					if hepPkt.ProtoType == uint32(v) {
-						select {
-						case h.lokiCh <- hepPkt:
-						default:
-							if time.Since(lastWarn) > 1e9 {
-								logp.Warn("overflowing loki channel")
-							}
-							lastWarn = time.Now()
-						}
-						break
-					}
-				}
-			}
+func (h *HEPInput) findSink(name string) *sink {
+	for _, s := range h.sinks {
+		if s.name == name {
+			return s
 		}
 	}
+	return nil
 }
 
 func (h *HEPInput) logStats() {
@@ -314,23 +315,15 @@ func (h *HEPInput) logStats() {
 
 func (h *HEPInput) reloadWorker() {
 	s := make(chan os.Signal, 1)
-	defer close(s)
+	defer signal.Stop(s)
 	signal.Notify(s, syscall.SIGHUP)
 
 	for {
 		select {
 		case <-s:
-			logp.Info("reload all worker")
-			h.wg.Add(1)
-
-			h.exitWorker <- true
-			<-h.exitWorker
-
-			for n := 0; n < runtime.NumCPU(); n++ {
-				h.wg.Add(1)
-				go h.worker()
+			if _, _, err := h.reload(); err != nil {
+				logp.Err("keep old configuration: %v", err)
 			}
-			h.wg.Done()
 		case <-h.quit:
 			h.quit <- true
 			return
@@ -0,0 +1,76 @@
+package input
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/sipcapture/heplify-server/decoder"
+)
+
+// newShards creates one bounded queue per worker. WorkerShards defaults
+// to the number of CPUs.
+func newShards() []chan inputPkt {
+	n := config.Get().WorkerShards
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	size := config.Get().WorkerBuffer
+	if size < 1 {
+		size = 1000
+	}
+	shards := make([]chan inputPkt, n)
+	for i := range shards {
+		shards[i] = make(chan inputPkt, size)
+	}
+	return shards
+}
+
+// dispatch hands packets with the same Call-ID or CID to the same worker,
+// so the messages of a dialog are processed in the order they arrived.
+// Packets which can't be peeked without decoding them, like compressed,
+// HEPv1/v2 and protobuf packets, stay in the order of their source. Other
+// packets without correlation ID are spread round robin.
+func (h *HEPInput) dispatch() {
+	defer func() {
+		for _, shard := range h.shards {
+			close(shard)
+		}
+	}()
+
+	n := uint64(len(h.shards))
+	var next uint64
+	for in := range h.inputCh {
+		var i uint64
+		if cid, ok := decoder.PeekCallID(in.buf); cid != nil {
+			i = xxhash.Sum64(cid) % n
+		} else if !ok && in.src != nil {
+			i = xxhash.Sum64(in.src) % n
+		} else {
+			next++
+			i = next % n
+		}
+		h.shards[i] <- in
+	}
+}
+
+// startWorkers starts one worker per shard. Queued packets stay in
+// the shards while the workers restart.
+func (h *HEPInput) startWorkers() {
+	exit := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, shard := range h.shards {
+		wg.Add(1)
+		h.wg.Add(1)
+		go func(shard chan inputPkt) {
+			defer h.wg.Done()
+			defer wg.Done()
+			h.worker(shard, exit)
+		}(shard)
+	}
+	h.stopWorker = func() {
+		close(exit)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,54 @@
+package input
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sipcapture/heplify-server/decoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatch(t *testing.T) {
+	h := &HEPInput{inputCh: make(chan inputPkt, 100)}
+	for i := 0; i < 4; i++ {
+		h.shards = append(h.shards, make(chan inputPkt, 100))
+	}
+
+	var sent [][]byte
+	for i := 0; i < 30; i++ {
+		b, err := decoder.EncodeHEP(&decoder.HEP{
+			Version:   2,
+			Protocol:  17,
+			SrcIP:     "10.0.0.1",
+			DstIP:     "10.0.0.2",
+			ProtoType: 1,
+			Payload:   fmt.Sprintf("SIP/2.0 %d OK\r\nCall-ID: call-%d\r\nCSeq: %d INVITE\r\n\r\n", 100+i, i%3, i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, b)
+		h.inputCh <- inputPkt{buf: b}
+	}
+	close(h.inputCh)
+	h.dispatch()
+
+	shardOf := map[string]int{}
+	order := map[string][]string{}
+	for i, shard := range h.shards {
+		for in := range shard {
+			id, _ := decoder.PeekCallID(in.buf)
+			cid := string(id)
+			if s, ok := shardOf[cid]; ok {
+				assert.Equal(t, s, i, "call %s is on two shards", cid)
+			}
+			shardOf[cid] = i
+			order[cid] = append(order[cid], string(in.buf))
+		}
+	}
+	assert.Equal(t, 3, len(shardOf))
+	for i, b := range sent {
+		cid := fmt.Sprintf("call-%d", i%3)
+		assert.Equal(t, string(b), order[cid][i/3])
+	}
+}
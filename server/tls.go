@@ -2,45 +2,149 @@ package input
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
-	"path/filepath"
 
-	"github.com/sipcapture/heplify-server/config"
 	"github.com/negbie/cert"
 	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
 )
 
+// tlsCerts holds the server certificate and the client CA pool.
+// Both can be swapped at runtime on SIGHUP. The self signed CA is
+// created once by the first load, caMu guards it against listeners
+// starting concurrently and against handshakes.
+type tlsCerts struct {
+	cert     atomic.Value // *tls.Certificate
+	clientCA atomic.Value // *x509.CertPool
+	caMu     sync.Mutex
+	ca       *cert.CertificateAuthority
+}
+
+// load reads TLSCertFile, TLSKeyFile and TLSClientCA. Without TLSCertFile
+// a self signed CA is generated or loaded from TLSCertFolder.
+func (t *tlsCerts) load() error {
+	cfg := config.Get()
+	if cfg.TLSCertFile != "" {
+		c, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		t.cert.Store(&c)
+	} else if err := t.loadCA(cfg.TLSCertFolder); err != nil {
+		return err
+	}
+
+	if cfg.TLSClientCA != "" {
+		pem, err := ioutil.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no valid certificate found in TLSClientCA %s", cfg.TLSClientCA)
+		}
+		t.clientCA.Store(pool)
+	}
+	return nil
+}
+
+// loadCA generates or loads the self signed CA unless it exists already.
+func (t *tlsCerts) loadCA(folder string) error {
+	t.caMu.Lock()
+	defer t.caMu.Unlock()
+	if t.ca != nil {
+		return nil
+	}
+	ca, err := cert.NewCertificateAuthority(filepath.Join(folder, "heplify-server"))
+	if err != nil {
+		return err
+	}
+	t.ca = ca
+	return nil
+}
+
+func (t *tlsCerts) serverConfig() *tls.Config {
+	cfg := &tls.Config{}
+	t.caMu.Lock()
+	ca := t.ca
+	t.caMu.Unlock()
+	if c, ok := t.cert.Load().(*tls.Certificate); ok && config.Get().TLSCertFile != "" {
+		cfg.Certificates = []tls.Certificate{*c}
+	} else if ca != nil {
+		cfg.GetCertificate = ca.GetCertificate
+	}
+	if pool, ok := t.clientCA.Load().(*x509.CertPool); ok && config.Get().TLSClientCA != "" {
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// dynamicConfig returns a config which picks up reloaded certificates
+// on every handshake.
+func (t *tlsCerts) dynamicConfig(protos ...string) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := t.serverConfig()
+			cfg.NextProtos = protos
+			return cfg, nil
+		},
+	}
+}
+
+// certNodeName returns the CN or the first DNS SAN of the client certificate.
+func certNodeName(cs tls.ConnectionState) string {
+	certs := cs.PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	if cn := certs[0].Subject.CommonName; cn != "" {
+		return cn
+	}
+	if len(certs[0].DNSNames) > 0 {
+		return certs[0].DNSNames[0]
+	}
+	return ""
+}
+
 func (h *HEPInput) serveTLS(addr string) {
-	defer close(h.exitTLS)
+	defer h.listenWg.Done()
+	stop := h.listeners.stopFlag("tls", addr)
 
 	ta, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		logp.Err("%v", err)
+		h.listeners.set("tls", addr, err)
 		return
 	}
 
 	ln, err := net.ListenTCP("tcp", ta)
 	if err != nil {
 		logp.Err("%v", err)
+		h.listeners.set("tls", addr, err)
 		return
 	}
 
-	// get path for certificate/key storage
-	cPath := config.Setting.TLSCertFolder
-	// load any existing certs, otherwise generate a new one
-	ca, err := cert.NewCertificateAuthority( filepath.Join(cPath,  "heplify-server") )
-	if err != nil {
+	if err = h.tlsCerts.load(); err != nil {
 		logp.Err("%v", err)
+		h.listeners.set("tls", addr, err)
+		ln.Close()
 		return
 	}
+	h.listeners.set("tls", addr, nil)
+	defer h.listeners.set("tls", addr, errListenerClosed)
 
 	var wg sync.WaitGroup
 
 	for {
-		if atomic.LoadUint32(&h.stopped) == 1 {
+		if h.stopping(stop) {
 			logp.Info("stopping TLS listener on %s", ln.Addr())
 			ln.Close()
 			wg.Wait()
@@ -62,37 +166,21 @@ func (h *HEPInput) serveTLS(addr string) {
 		logp.Info("new TLS connection %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
 		wg.Add(1)
 		go func() {
-			h.handleTLS(tls.Server(conn, &tls.Config{GetCertificate: ca.GetCertificate}))
-			wg.Done()
-		}()
-	}
-}
-
-func (h *HEPInput) handleTLS(c net.Conn) {
-	defer func() {
-		logp.Info("closing TLS connection from %s", c.RemoteAddr())
-		err := c.Close()
-		if err != nil {
-			logp.Err("%v", err)
-		}
-	}()
-
-	for {
-		if atomic.LoadUint32(&h.stopped) == 1 {
-			return
-		}
+			defer wg.Done()
+			tlsConn := tls.Server(conn, h.tlsCerts.serverConfig())
+			tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+			if err := tlsConn.Handshake(); err != nil {
+				logp.Warn("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+				tlsConn.Close()
+				return
+			}
+			tlsConn.SetDeadline(time.Time{})
 
-		buf := h.buffer.Get().([]byte)
-		n, err := c.Read(buf)
-		if err != nil {
-			logp.Warn("%v from %s", err, c.RemoteAddr())
-			return
-		} else if n > maxPktLen {
-			logp.Warn("received too big packet with %d bytes", n)
-			atomic.AddUint64(&h.stats.ErrCount, 1)
-			continue
-		}
-		h.inputCh <- buf[:n]
-		atomic.AddUint64(&h.stats.PktCount, 1)
+			var nodeName string
+			if config.Get().TLSCertNodeName {
+				nodeName = certNodeName(tlsConn.ConnectionState())
+			}
+			h.handleStream(tlsConn, tlsConn, "TLS", nodeName, stop)
+		}()
 	}
 }
@@ -0,0 +1,137 @@
+package input
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipcapture/heplify-server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCert(t *testing.T, dir, name string, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	c, _ := x509.ParseCertificate(der)
+	return c, key
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpl := func(serial int64, cn string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+	}
+	caTmpl := tmpl(1, "test-ca")
+	caTmpl.IsCA = true
+	caTmpl.BasicConstraintsValid = true
+	caTmpl.KeyUsage = x509.KeyUsageCertSign
+	ca, caKey := writeCert(t, dir, "ca", caTmpl, nil, nil)
+	writeCert(t, dir, "server", tmpl(2, "heplify-server"), ca, caKey)
+	writeCert(t, dir, "client", tmpl(3, "sbc-edge-1"), ca, caKey)
+
+	cfg := config.Setting
+	cfg.TLSCertFile = filepath.Join(dir, "server.crt")
+	cfg.TLSKeyFile = filepath.Join(dir, "server.key")
+	cfg.TLSClientCA = filepath.Join(dir, "ca.crt")
+	config.Set(&cfg)
+	defer config.Set(nil)
+
+	certs := &tlsCerts{}
+	if err := certs.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		certs    []tls.Certificate
+		nodeName string
+		ok       bool
+	}{
+		{[]tls.Certificate{clientCert}, "sbc-edge-1", true},
+		{nil, "", false},
+	} {
+		sc, cc := net.Pipe()
+		server := tls.Server(sc, certs.serverConfig())
+		client := tls.Client(cc, &tls.Config{Certificates: tc.certs, InsecureSkipVerify: true})
+		go func() {
+			client.Handshake()
+			cc.Close()
+		}()
+		err := server.Handshake()
+		assert.Equal(t, tc.ok, err == nil)
+		assert.Equal(t, tc.nodeName, certNodeName(server.ConnectionState()))
+		sc.Close()
+	}
+}
+
+func TestTLSConcurrentLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := config.Setting
+	cfg.TLSCertFolder = dir
+	config.Set(&cfg)
+	defer config.Set(nil)
+
+	// listeners and reloads load the CA while handshakes read it
+	certs := &tlsCerts{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, certs.load())
+		}()
+		go func() {
+			defer wg.Done()
+			certs.serverConfig()
+		}()
+	}
+	wg.Wait()
+	assert.NotNil(t, certs.serverConfig().GetCertificate)
+}
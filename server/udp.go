@@ -1,40 +1,84 @@
 package input
 
 import (
+	"context"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/negbie/logp"
+	"github.com/sipcapture/heplify-server/config"
 )
 
+// serveUDP opens HEPUDPSockets sockets on addr. More than one socket
+// needs SO_REUSEPORT and every socket gets its own reader.
 func (h *HEPInput) serveUDP(addr string) {
-	defer close(h.exitUDP)
+	defer h.listenWg.Done()
+	stop := h.listeners.stopFlag("udp", addr)
 
-	ua, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		logp.Err("%v", err)
-		return
+	n := config.Get().HEPUDPSockets
+	if n < 1 {
+		n = 1
+	}
+	lc := net.ListenConfig{}
+	if n > 1 {
+		if !reusePortSupported {
+			logp.Warn("SO_REUSEPORT is not supported on this platform, open one UDP socket on %s", addr)
+			n = 1
+		} else {
+			lc.Control = reusePortControl
+		}
 	}
 
-	uc, err := net.ListenUDP("udp", ua)
-	if err != nil {
-		logp.Err("%v", err)
-		return
+	conns := make([]*net.UDPConn, 0, n)
+	for i := 0; i < n; i++ {
+		pc, err := lc.ListenPacket(context.Background(), "udp", addr)
+		if err != nil {
+			logp.Err("%v", err)
+			h.listeners.set("udp", addr, err)
+			for _, uc := range conns {
+				uc.Close()
+			}
+			return
+		}
+		uc := pc.(*net.UDPConn)
+		if size := config.Get().HEPUDPReadBuffer; size > 0 {
+			if err = setReadBuffer(uc, size); err != nil {
+				logp.Warn("set UDP read buffer of %d bytes on %s: %v", size, addr, err)
+			}
+		}
+		conns = append(conns, uc)
 	}
+	h.listeners.set("udp", addr, nil)
+	defer h.listeners.set("udp", addr, errListenerClosed)
+	h.udpSockets.add(addr, conns)
+	defer h.udpSockets.remove(addr)
 
+	var wg sync.WaitGroup
+	for _, uc := range conns {
+		wg.Add(1)
+		go func(uc *net.UDPConn) {
+			defer wg.Done()
+			h.readUDP(uc, stop)
+		}(uc)
+	}
+	wg.Wait()
+}
+
+func (h *HEPInput) readUDP(uc *net.UDPConn, stop *uint32) {
 	defer func() {
 		logp.Info("stopping UDP listener on %s", uc.LocalAddr())
 		uc.Close()
 	}()
 
 	for {
-		if atomic.LoadUint32(&h.stopped) == 1 {
+		if h.stopping(stop) {
 			return
 		}
 		uc.SetReadDeadline(time.Now().Add(1e9))
 		buf := h.buffer.Get().([]byte)
-		n, err := uc.Read(buf)
+		n, addr, err := uc.ReadFromUDP(buf)
 		if err != nil {
 			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
 				continue
@@ -45,9 +89,58 @@ func (h *HEPInput) serveUDP(addr string) {
 		} else if n > maxPktLen {
 			logp.Warn("received too big packet with %d bytes", n)
 			atomic.AddUint64(&h.stats.ErrCount, 1)
+			pktError.Inc()
 			continue
 		}
-		h.inputCh <- buf[:n]
+		h.inputCh <- inputPkt{buf: buf[:n], src: addr.IP, proto: "udp"}
 		atomic.AddUint64(&h.stats.PktCount, 1)
+		pktReceived.Inc()
+	}
+}
+
+// udpSockets keeps the open UDP sockets per listen address for the drop counters.
+type udpSockets struct {
+	mu    sync.Mutex
+	conns map[string][]*net.UDPConn
+}
+
+func (u *udpSockets) add(addr string, conns []*net.UDPConn) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.conns == nil {
+		u.conns = make(map[string][]*net.UDPConn)
+	}
+	u.conns[addr] = conns
+}
+
+func (u *udpSockets) remove(addr string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.conns, addr)
+}
+
+// drops returns the kernel drop counter per listen address.
+func (u *udpSockets) drops() map[string]uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.conns) == 0 {
+		return nil
+	}
+	inodes := make(map[uint64]string)
+	for addr, conns := range u.conns {
+		for _, uc := range conns {
+			if inode, err := socketInode(uc); err == nil {
+				inodes[inode] = addr
+			}
+		}
+	}
+	if len(inodes) == 0 {
+		return nil
+	}
+	drops, err := socketDrops(inodes)
+	if err != nil {
+		logp.Debug("udp", "read UDP socket drops: %v", err)
+		return nil
 	}
+	return drops
 }
@@ -0,0 +1,109 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const reusePortSupported = true
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// setReadBuffer tries SO_RCVBUFFORCE to exceed net.core.rmem_max and
+// falls back to SO_RCVBUF without CAP_NET_ADMIN.
+func setReadBuffer(uc *net.UDPConn, size int) error {
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if cerr := rc.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, size)
+	}); cerr != nil {
+		return cerr
+	}
+	if err == nil {
+		return nil
+	}
+	return uc.SetReadBuffer(size)
+}
+
+// socketInode returns the inode which identifies the socket in /proc/net/udp.
+func socketInode(uc *net.UDPConn) (uint64, error) {
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var link string
+	if cerr := rc.Control(func(fd uintptr) {
+		link, err = os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+	}); cerr != nil {
+		return 0, cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(link, "socket:[") {
+		return 0, fmt.Errorf("fd is no socket: %s", link)
+	}
+	return strconv.ParseUint(strings.Trim(link[7:], "[]"), 10, 64)
+}
+
+// socketDrops sums the drops column of /proc/net/udp and /proc/net/udp6
+// for the sockets in inodes by their listen address.
+func socketDrops(inodes map[uint64]string) (map[string]uint64, error) {
+	drops := make(map[string]uint64)
+	for _, name := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		f, err := os.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		err = parseSocketDrops(bufio.NewScanner(f), inodes, drops)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return drops, nil
+}
+
+func parseSocketDrops(s *bufio.Scanner, inodes map[uint64]string, drops map[string]uint64) error {
+	s.Scan() // header
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 13 {
+			continue
+		}
+		inode, err := strconv.ParseUint(f[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		addr, ok := inodes[inode]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(f[len(f)-1], 10, 64)
+		if err != nil {
+			return err
+		}
+		drops[addr] += n
+	}
+	return s.Err()
+}
@@ -0,0 +1,46 @@
+package input
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSocketDrops(t *testing.T) {
+	udp := `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+ 1024: 00000000:2364 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 41001 2 0000000000000000 17
+ 1025: 00000000:2364 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 41002 2 0000000000000000 25
+ 1026: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 41003 2 0000000000000000 99
+`
+	drops := map[string]uint64{}
+	err := parseSocketDrops(bufio.NewScanner(strings.NewReader(udp)),
+		map[uint64]string{41001: "0.0.0.0:9060", 41002: "0.0.0.0:9060"}, drops)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"0.0.0.0:9060": 42}, drops)
+}
+
+func TestReusePort(t *testing.T) {
+	lc := net.ListenConfig{Control: reusePortControl}
+	a, err := lc.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := lc.ListenPacket(context.Background(), "udp", a.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	u := &udpSockets{}
+	u.add("test", []*net.UDPConn{a.(*net.UDPConn), b.(*net.UDPConn)})
+	drops := u.drops()
+	if _, ok := drops["test"]; !ok {
+		t.Skip("no /proc/net/udp in this environment")
+	}
+	assert.Equal(t, uint64(0), drops["test"])
+}
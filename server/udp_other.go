@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package input
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+const reusePortSupported = false
+
+func reusePortControl(network, address string, c syscall.RawConn) error { return nil }
+
+func setReadBuffer(uc *net.UDPConn, size int) error { return uc.SetReadBuffer(size) }
+
+func socketInode(uc *net.UDPConn) (uint64, error) {
+	return 0, errors.New("socket inodes are only supported on linux")
+}
+
+func socketDrops(inodes map[uint64]string) (map[string]uint64, error) {
+	return nil, errors.New("socket drops are only supported on linux")
+}
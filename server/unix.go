@@ -0,0 +1,108 @@
+package input
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+// removeStaleSocket removes a socket file left over from a previous run.
+// Other files are kept so that a wrong path can't delete them.
+func removeStaleSocket(path string) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+}
+
+func (h *HEPInput) serveUnix(addr string) {
+	defer h.listenWg.Done()
+	stop := h.listeners.stopFlag("unix", addr)
+
+	removeStaleSocket(addr)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+	if err != nil {
+		logp.Err("%v", err)
+		h.listeners.set("unix", addr, err)
+		return
+	}
+	h.listeners.set("unix", addr, nil)
+	defer h.listeners.set("unix", addr, errListenerClosed)
+
+	var wg sync.WaitGroup
+
+	for {
+		if h.stopping(stop) {
+			logp.Info("stopping Unix listener on %s", addr)
+			ln.Close()
+			wg.Wait()
+			return
+		}
+
+		if err := ln.SetDeadline(time.Now().Add(1e9)); err != nil {
+			logp.Err("%v", err)
+			ln.Close()
+			break
+		}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); !ok || !opErr.Timeout() {
+				logp.Err("failed to accept Unix connection: %v", err.Error())
+			}
+			continue
+		}
+		logp.Info("new Unix connection on %s", addr)
+		wg.Add(1)
+		go func() {
+			h.handleStream(conn, conn, "Unix", "", stop)
+			wg.Done()
+		}()
+	}
+}
+
+func (h *HEPInput) serveUnixgram(addr string) {
+	defer h.listenWg.Done()
+	stop := h.listeners.stopFlag("unixgram", addr)
+
+	removeStaleSocket(addr)
+	uc, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		logp.Err("%v", err)
+		h.listeners.set("unixgram", addr, err)
+		return
+	}
+	h.listeners.set("unixgram", addr, nil)
+	defer h.listeners.set("unixgram", addr, errListenerClosed)
+
+	defer func() {
+		logp.Info("stopping Unixgram listener on %s", addr)
+		uc.Close()
+		// datagram sockets are not unlinked on close
+		os.Remove(addr)
+	}()
+
+	for {
+		if h.stopping(stop) {
+			return
+		}
+
+		uc.SetReadDeadline(time.Now().Add(1e9))
+		buf := h.buffer.Get().([]byte)
+		n, err := uc.Read(buf)
+		if err != nil {
+			h.buffer.Put(buf)
+			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+				continue
+			}
+			logp.Err("%v", err)
+			return
+		}
+		h.inputCh <- inputPkt{buf: buf[:n], proto: "unixgram"}
+		atomic.AddUint64(&h.stats.PktCount, 1)
+		pktReceived.Inc()
+	}
+}
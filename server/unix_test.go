@@ -0,0 +1,61 @@
+package input
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnixListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heplify-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := &HEPInput{
+		inputCh: make(chan inputPkt, 10),
+		buffer:  &sync.Pool{New: func() interface{} { return make([]byte, maxPktLen) }},
+	}
+	stream, dgram := filepath.Join(dir, "hep.sock"), filepath.Join(dir, "hepgram.sock")
+	h.listenWg.Add(2)
+	go h.serveUnix(stream)
+	go h.serveUnixgram(dgram)
+
+	pkt := []byte{0x48, 0x45, 0x50, 0x33, 0x00, 0x06}
+	dial := func(network, addr string) net.Conn {
+		for i := 0; i < 100; i++ {
+			if c, err := net.Dial(network, addr); err == nil {
+				return c
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("dial %s %s failed", network, addr)
+		return nil
+	}
+	for _, c := range []net.Conn{dial("unix", stream), dial("unixgram", dgram)} {
+		if _, err := c.Write(pkt); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case in := <-h.inputCh:
+			assert.Equal(t, pkt, in.buf)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no packet received")
+		}
+		c.Close()
+	}
+
+	atomic.StoreUint32(&h.stopped, 1)
+	h.listenWg.Wait()
+	for _, l := range h.listeners.list() {
+		assert.False(t, l.Bound)
+	}
+}
@@ -1,35 +1,38 @@
 package input
 
 import (
-	"github.com/gobwas/ws"
-	"io"
 	"net"
 	"sync"
-	"sync/atomic"
 	"time"
 
+	"github.com/gobwas/ws"
 	"github.com/negbie/logp"
 )
 
 func (h *HEPInput) serveWS(addr string) {
-	defer close(h.exitWS)
+	defer h.listenWg.Done()
+	stop := h.listeners.stopFlag("ws", addr)
 
 	ta, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		logp.Err("%v", err)
+		h.listeners.set("ws", addr, err)
 		return
 	}
 
 	ln, err := net.ListenTCP("tcp", ta)
 	if err != nil {
 		logp.Err("%v", err)
+		h.listeners.set("ws", addr, err)
 		return
 	}
+	h.listeners.set("ws", addr, nil)
+	defer h.listeners.set("ws", addr, errListenerClosed)
 
 	var wg sync.WaitGroup
 
 	for {
-		if atomic.LoadUint32(&h.stopped) == 1 {
+		if h.stopping(stop) {
 			logp.Info("stopping WS listener on %s", ln.Addr())
 			ln.Close()
 			wg.Wait()
@@ -48,51 +51,16 @@ func (h *HEPInput) serveWS(addr string) {
 			}
 			continue
 		}
-		_, err = ws.Upgrade(conn)
-		if err != nil {
-			logp.Err("%v", err)
-			return
-		}
-
 		logp.Info("new WS connection %s -> %s", conn.RemoteAddr(), conn.LocalAddr())
 		wg.Add(1)
 		go func() {
-			h.handleWS(conn)
-			wg.Done()
+			defer wg.Done()
+			if _, err := ws.Upgrade(conn); err != nil {
+				logp.Warn("failed to upgrade WS connection from %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			h.handleStream(conn, newWSReader(conn), "WS", "", stop)
 		}()
 	}
 }
-
-func (h *HEPInput) handleWS(c net.Conn) {
-	defer func() {
-		logp.Info("closing WS connection from %s", c.RemoteAddr())
-		err := c.Close()
-		if err != nil {
-			logp.Err("%v", err)
-		}
-	}()
-	for {
-		header, err := ws.ReadHeader(c)
-		if err != nil {
-			logp.Err("%v", err)
-			return
-		}
-		buf := h.buffer.Get().([]byte)
-		payload := buf[:header.Length]
-		_, err = io.ReadFull(c, payload)
-		if err != nil {
-			logp.Err("%v", err)
-			return
-		}
-
-		if header.Masked {
-			ws.Cipher(payload, header.Mask, 0)
-		}
-
-		if header.OpCode == ws.OpClose {
-			return
-		}
-		h.inputCh <- payload
-		atomic.AddUint64(&h.stats.PktCount, 1)
-	}
-}